@@ -1,8 +1,10 @@
 package compactmap
 
 import (
+	"math/rand"
 	"testing"
 
+	"github.com/goupdate/compactmap/tuple"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -148,3 +150,81 @@ func TestLoadOrStore(t *testing.T) {
 	assert.False(t, exists, "Value for key 3 should exist after LoadOrStore")
 	assert.Equal(t, 400, value, "Value for key 3 should be 400 after LoadOrStore")
 }
+
+func TestOverwriteInEarlierBuffer(t *testing.T) {
+	cm := NewCompactMap[int, int]()
+	for i := 0; i < 3000; i++ {
+		cm.AddOrSet(i, i)
+	}
+
+	overwrited := cm.AddOrSet(5, 500)
+	assert.True(t, overwrited, "Key 5 should be overwritten")
+	assert.Equal(t, 3000, cm.Count())
+
+	value, exists := cm.Get(5)
+	assert.True(t, exists)
+	assert.Equal(t, 500, value, "Get should return the new value")
+
+	cm.Delete(5)
+	assert.False(t, cm.Exist(5), "Key 5 should not exist after deletion")
+}
+
+func TestRandomOrder(t *testing.T) {
+	cm := NewCompactMap[int, int]()
+	ref := map[int]int{}
+	for i := 0; i < 20000; i++ {
+		key := rand.Intn(5000)
+		switch rand.Intn(3) {
+		case 0:
+			cm.Delete(key)
+			delete(ref, key)
+		default:
+			cm.AddOrSet(key, i)
+			ref[key] = i
+		}
+	}
+
+	assert.Nil(t, cm.Validate())
+	assert.Equal(t, len(ref), cm.Count())
+	prev := -1
+	cm.Iterate(func(key, value int) bool {
+		assert.True(t, key > prev, "Keys should be iterated in sorted order")
+		assert.Equal(t, ref[key], value)
+		prev = key
+		return true
+	})
+}
+
+func TestIterateRange(t *testing.T) {
+	cm := NewCompactMap[string, int]()
+	for tenant := 1; tenant <= 10; tenant++ {
+		for ts := 0; ts < 500; ts++ {
+			cm.AddOrSet(tuple.Pack(tenant, int64(ts)), ts)
+		}
+	}
+
+	var got []int
+	cm.IterateRange(tuple.Pack(7, int64(100)), tuple.Pack(7, int64(110)), func(key string, val int) bool {
+		got = append(got, val)
+		return true
+	})
+	assert.Equal(t, []int{100, 101, 102, 103, 104, 105, 106, 107, 108, 109}, got)
+
+	from, to := tuple.PrefixRange(7)
+	count := 0
+	cm.IterateRange(from, to, func(key string, val int) bool {
+		values, err := tuple.Unpack(key)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(7), values[0])
+		count++
+		return true
+	})
+	assert.Equal(t, 500, count)
+
+	count = 0
+	cm.IterateFrom(tuple.Pack(10, int64(495)), func(key string, val int) bool {
+		count++
+		return true
+	})
+	assert.Equal(t, 5, count)
+}
@@ -0,0 +1,249 @@
+package compactmap
+
+import (
+	"encoding/binary"
+	"slices"
+	"sort"
+)
+
+// keyArena stores sorted string keys of a buffer in one byte slice
+type keyArena interface {
+	len() int
+	last() []byte                              // the greatest key, arena should not be empty
+	search(key string) (index int, found bool) // position of key or where to insert it
+	insert(i int, key string)
+	remove(i int)
+	split() keyArena // moves the upper half of keys into a new arena
+	each(from int, fn func(i int, key []byte) bool) bool
+	bytes() int
+}
+
+// plainArena keeps keys one after another with offsets of their starts
+
+type plainArena struct {
+	data    []byte
+	offsets []uint32
+}
+
+func (a *plainArena) len() int {
+	return len(a.offsets)
+}
+
+func (a *plainArena) key(i int) []byte {
+	end := len(a.data)
+	if i+1 < len(a.offsets) {
+		end = int(a.offsets[i+1])
+	}
+	return a.data[a.offsets[i]:end]
+}
+
+func (a *plainArena) last() []byte {
+	return a.key(len(a.offsets) - 1)
+}
+
+func (a *plainArena) search(key string) (int, bool) {
+	i := sort.Search(len(a.offsets), func(i int) bool {
+		return string(a.key(i)) >= key
+	})
+	return i, i < len(a.offsets) && string(a.key(i)) == key
+}
+
+func (a *plainArena) insert(i int, key string) {
+	pos := len(a.data)
+	if i < len(a.offsets) {
+		pos = int(a.offsets[i])
+	}
+
+	n := len(key)
+	a.data = append(a.data, key...) // grow by len(key)
+	copy(a.data[pos+n:], a.data[pos:len(a.data)-n])
+	copy(a.data[pos:], key)
+
+	for j := i; j < len(a.offsets); j++ {
+		a.offsets[j] += uint32(n)
+	}
+	a.offsets = slices.Insert(a.offsets, i, uint32(pos))
+}
+
+func (a *plainArena) remove(i int) {
+	k := a.key(i)
+	start, n := int(a.offsets[i]), len(k)
+	a.data = append(a.data[:start], a.data[start+n:]...)
+
+	a.offsets = slices.Delete(a.offsets, i, i+1)
+	for j := i; j < len(a.offsets); j++ {
+		a.offsets[j] -= uint32(n)
+	}
+}
+
+func (a *plainArena) split() keyArena {
+	half := len(a.offsets) / 2
+	start := a.offsets[half]
+
+	right := &plainArena{
+		data:    slices.Clone(a.data[start:]),
+		offsets: make([]uint32, len(a.offsets)-half),
+	}
+	for j := range right.offsets {
+		right.offsets[j] = a.offsets[half+j] - start
+	}
+
+	a.data = a.data[:start]
+	a.offsets = a.offsets[:half]
+	return right
+}
+
+func (a *plainArena) each(from int, fn func(i int, key []byte) bool) bool {
+	for i := from; i < len(a.offsets); i++ {
+		if !fn(i, a.key(i)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *plainArena) bytes() int {
+	return cap(a.data) + 4*cap(a.offsets)
+}
+
+// frontArena stores every key as the length of prefix shared with the previous key and the rest.
+// Every restartInterval-th key is stored whole, so search decodes at most restartInterval keys.
+// Inserts in the middle re-encode the arena.
+
+const restartInterval = 16
+
+type frontArena struct {
+	data     []byte   // every key: uvarint shared length, uvarint suffix length, suffix
+	restarts []uint32 // offsets of keys stored whole
+	n        int
+	lastKey  []byte
+}
+
+func (a *frontArena) len() int {
+	return a.n
+}
+
+func (a *frontArena) last() []byte {
+	return a.lastKey
+}
+
+// next decodes key at off, prev is the previous key and is overwritten
+func (a *frontArena) next(off int, prev []byte) ([]byte, int) {
+	shared, k := binary.Uvarint(a.data[off:])
+	off += k
+	n, k := binary.Uvarint(a.data[off:])
+	off += k
+	key := append(prev[:shared], a.data[off:off+int(n)]...)
+	return key, off + int(n)
+}
+
+// restartKey returns key stored whole without copying
+func (a *frontArena) restartKey(j int) []byte {
+	off := int(a.restarts[j]) + 1 // shared length is 0, one byte
+	n, k := binary.Uvarint(a.data[off:])
+	off += k
+	return a.data[off : off+int(n)]
+}
+
+func (a *frontArena) search(key string) (int, bool) {
+	// first group starting with a key greater than key, key is in the group before it
+	g := sort.Search(len(a.restarts), func(j int) bool {
+		return string(a.restartKey(j)) > key
+	}) - 1
+	if g < 0 {
+		return 0, false
+	}
+
+	var cur []byte
+	off := int(a.restarts[g])
+	i := g * restartInterval
+	for end := min(a.n, i+restartInterval); i < end; i++ {
+		cur, off = a.next(off, cur)
+		if string(cur) >= key {
+			return i, string(cur) == key
+		}
+	}
+	return i, false
+}
+
+func (a *frontArena) appendKey(key []byte) {
+	shared := 0
+	if a.n%restartInterval == 0 {
+		a.restarts = append(a.restarts, uint32(len(a.data)))
+	} else {
+		for shared < len(key) && shared < len(a.lastKey) && key[shared] == a.lastKey[shared] {
+			shared++
+		}
+	}
+	a.data = binary.AppendUvarint(a.data, uint64(shared))
+	a.data = binary.AppendUvarint(a.data, uint64(len(key)-shared))
+	a.data = append(a.data, key[shared:]...)
+	a.lastKey = append(a.lastKey[:0], key...)
+	a.n++
+}
+
+func (a *frontArena) insert(i int, key string) {
+	if i == a.n {
+		a.appendKey([]byte(key))
+		return
+	}
+
+	b := &frontArena{data: make([]byte, 0, len(a.data)+len(key)+2*binary.MaxVarintLen32)}
+	inserted := []byte(key)
+	a.each(0, func(j int, k []byte) bool {
+		if j == i {
+			b.appendKey(inserted)
+		}
+		b.appendKey(k)
+		return true
+	})
+	*a = *b
+}
+
+func (a *frontArena) remove(i int) {
+	b := &frontArena{data: make([]byte, 0, len(a.data))}
+	a.each(0, func(j int, k []byte) bool {
+		if j != i {
+			b.appendKey(k)
+		}
+		return true
+	})
+	*a = *b
+}
+
+func (a *frontArena) split() keyArena {
+	half := a.n / 2
+	left := &frontArena{}
+	right := &frontArena{}
+	a.each(0, func(j int, k []byte) bool {
+		if j < half {
+			left.appendKey(k)
+		} else {
+			right.appendKey(k)
+		}
+		return true
+	})
+	*a = *left
+	return right
+}
+
+func (a *frontArena) each(from int, fn func(i int, key []byte) bool) bool {
+	if from >= a.n {
+		return true
+	}
+
+	var cur []byte
+	g := from / restartInterval
+	off := int(a.restarts[g])
+	for i := g * restartInterval; i < a.n; i++ {
+		cur, off = a.next(off, cur)
+		if i >= from && !fn(i, cur) {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *frontArena) bytes() int {
+	return cap(a.data) + 4*cap(a.restarts) + cap(a.lastKey)
+}
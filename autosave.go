@@ -0,0 +1,160 @@
+package compactmap
+
+import (
+	"sync"
+	"time"
+)
+
+// AutoSaveStats describes saves made by EnableAutoSave and Close
+type AutoSaveStats struct {
+	Saves        int           // successful saves
+	Errors       int           // failed saves
+	LastSave     time.Time     // start of the last save
+	LastDuration time.Duration // duration of the last save
+	LastError    error         // error of the last save, nil if it succeeded
+	Pending      int64         // changes not saved yet, see Mutations
+}
+
+type autoSaver struct {
+	filename   string
+	minChanges int64
+	onError    func(error)
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+
+	mu    sync.Mutex
+	stats AutoSaveStats
+}
+
+// halt stops the saving goroutine and waits until it exits
+func (s *autoSaver) halt() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		<-s.done
+	})
+}
+
+// Mutations returns the number of changes not saved into the own file of the map:
+// the auto save file if it is enabled, otherwise the file loaded or saved before.
+// Saves into other files, such as backups, do not reset it.
+func (m *CompactMap[K, V]) Mutations() int64 {
+	return m.mutations.Load()
+}
+
+// EnableAutoSave saves the map into filename every interval
+// if it was changed at least minChanges times since the last save.
+// onError, if not nil, is called with errors of background saves.
+// Calling it again replaces the previous settings, see Close for the final save.
+// It panics if interval is not positive.
+func (m *CompactMap[K, V]) EnableAutoSave(filename string, interval time.Duration, minChanges int, onError func(error)) {
+	if interval <= 0 {
+		panic("compactmap: non-positive autosave interval")
+	}
+
+	s := &autoSaver{
+		filename:   filename,
+		minChanges: int64(max(minChanges, 1)),
+		onError:    onError,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	m.Lock()
+	old := m.autosave
+	m.autosave = s
+	m.Unlock()
+
+	if old != nil {
+		old.halt()
+	}
+
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				m.autoSave(s, false)
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// DisableAutoSave stops background saves without saving pending changes
+func (m *CompactMap[K, V]) DisableAutoSave() {
+	m.RLock()
+	s := m.autosave
+	m.RUnlock()
+
+	if s != nil {
+		s.halt()
+	}
+}
+
+// AutoSaveStats returns counters of saves made by EnableAutoSave and Close
+func (m *CompactMap[K, V]) AutoSaveStats() AutoSaveStats {
+	m.RLock()
+	s := m.autosave
+	m.RUnlock()
+
+	var stats AutoSaveStats
+	if s != nil {
+		s.mu.Lock()
+		stats = s.stats
+		s.mu.Unlock()
+	}
+	stats.Pending = m.mutations.Load()
+	return stats
+}
+
+// Close stops the janitor and background saves.
+// With auto save enabled pending changes are saved into its file whatever minChanges is.
+func (m *CompactMap[K, V]) Close() error {
+	m.Lock()
+	m.stopJanitor()
+	s := m.autosave
+	m.Unlock()
+
+	if s == nil {
+		return nil
+	}
+	s.halt()
+	return m.autoSave(s, true)
+}
+
+// autoSave saves the map if it has enough changes, force saves any changes
+func (m *CompactMap[K, V]) autoSave(s *autoSaver, force bool) error {
+	m.RLock()
+	dirty := m.changed && (force || m.mutations.Load() >= s.minChanges)
+	m.RUnlock()
+
+	if !dirty {
+		return nil
+	}
+
+	start := time.Now()
+	err := m.Save(s.filename)
+
+	s.mu.Lock()
+	s.stats.LastSave = start
+	s.stats.LastDuration = time.Since(start)
+	s.stats.LastError = err
+	if err != nil {
+		s.stats.Errors++
+	} else {
+		s.stats.Saves++
+	}
+	s.mu.Unlock()
+
+	if err != nil && !force && s.onError != nil {
+		s.onError(err)
+	}
+	return err
+}
@@ -0,0 +1,134 @@
+package compactmap
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAutoSave(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "autosave.dat")
+
+	cm := NewCompactMap[int, int]()
+	cm.EnableAutoSave(file, 10*time.Millisecond, 3, nil)
+
+	cm.AddOrSet(1, 1)
+	cm.AddOrSet(2, 2)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 0, cm.AutoSaveStats().Saves, "Fewer changes than minChanges should not be saved")
+	assert.Equal(t, int64(2), cm.Mutations())
+
+	cm.Delete(1)
+	assert.Eventually(t, func() bool { return cm.AutoSaveStats().Saves == 1 }, time.Second, 5*time.Millisecond)
+	stats := cm.AutoSaveStats()
+	assert.NoError(t, stats.LastError)
+	assert.False(t, stats.LastSave.IsZero())
+	assert.Equal(t, int64(0), stats.Pending)
+
+	// final save of changes below minChanges
+	cm.AddOrSet(3, 3)
+	assert.NoError(t, cm.Close())
+	assert.Equal(t, 2, cm.AutoSaveStats().Saves)
+
+	loaded := NewCompactMap[int, int]()
+	assert.NoError(t, loaded.Init(file))
+	assert.Equal(t, 2, loaded.Count())
+	_, ok := loaded.Get(3)
+	assert.True(t, ok)
+
+	// no saves after Close
+	cm.AddOrSet(4, 4)
+	cm.AddOrSet(5, 5)
+	cm.AddOrSet(6, 6)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 2, cm.AutoSaveStats().Saves)
+}
+
+func TestAutoSaveError(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "missing", "autosave.dat")
+
+	errs := make(chan error, 10)
+	cm := NewCompactMap[int, int]()
+	cm.EnableAutoSave(file, 10*time.Millisecond, 1, func(err error) {
+		errs <- err
+	})
+	cm.AddOrSet(1, 1)
+
+	select {
+	case err := <-errs:
+		assert.Error(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Error callback was not called")
+	}
+
+	cm.DisableAutoSave()
+	stats := cm.AutoSaveStats()
+	assert.Error(t, stats.LastError)
+	assert.True(t, stats.Errors > 0)
+	assert.Equal(t, 0, stats.Saves)
+	assert.Error(t, cm.Close(), "Close should return error of the final save")
+}
+
+func TestAutoSaveBadInterval(t *testing.T) {
+	cm := NewCompactMap[int, int]()
+	assert.Panics(t, func() { cm.EnableAutoSave("x.dat", 0, 1, nil) })
+	assert.Nil(t, cm.Close())
+}
+
+// background saves and explicit saves into the same file run one at a time
+func TestAutoSaveConcurrentSave(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "auto.dat")
+
+	cm := NewCompactMap[int, int]()
+	errs := make(chan error, 100)
+	cm.EnableAutoSave(file, time.Millisecond, 1, func(err error) {
+		errs <- err
+	})
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				cm.AddOrSet(g*1000+i, i)
+				if i%10 == 0 {
+					assert.NoError(t, cm.Save(file))
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	assert.NoError(t, cm.Close())
+	assert.Empty(t, errs)
+
+	loaded := NewCompactMap[int, int]()
+	assert.NoError(t, loaded.Init(file))
+	assert.Equal(t, 800, loaded.Count())
+	assert.Equal(t, int64(0), cm.Mutations())
+	tmp, _ := filepath.Glob(file + ".*.tmp")
+	assert.Empty(t, tmp)
+}
+
+func TestAutoSaveAfterBackup(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "auto.dat")
+	backup := filepath.Join(dir, "backup.dat")
+
+	cm := NewCompactMap[int, int]()
+	cm.EnableAutoSave(file, time.Hour, 1, nil)
+	cm.AddOrSet(1, 1)
+
+	// a backup does not save changes into the auto save file
+	assert.NoError(t, cm.Save(backup))
+	assert.Equal(t, int64(1), cm.Mutations())
+
+	assert.NoError(t, cm.Close())
+	loaded := NewCompactMap[int, int]()
+	assert.NoError(t, loaded.Init(file))
+	assert.Equal(t, 1, loaded.Count())
+	assert.Equal(t, int64(0), cm.Mutations())
+}
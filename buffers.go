@@ -0,0 +1,99 @@
+package compactmap
+
+import (
+	"slices"
+	"sort"
+)
+
+/*
+	Buffers are ordered by keys: every buffer is sorted and all keys of a buffer
+	are less than keys of the next one, so every key has exactly one buffer it may live in.
+
+	Functions below keep this layout for any key order. Search takes the order as cmp,
+	CompactMapFunc passes the function given to NewCompactMapFunc, while CompactMap
+	searches ordered keys with operators, see find. Inserts and removals do not compare keys.
+*/
+
+// findBufferFunc returns index of the buffer for key: the first buffer with last key >= key
+// or the last buffer. buffers should not be empty.
+func findBufferFunc[K any, V any](buffers []*[]Entry[K, V], key K, cmp func(a, b K) int) int {
+	n := len(buffers)
+	bufferIndex := sort.Search(n, func(i int) bool {
+		buffer := *buffers[i]
+		return cmp(buffer[len(buffer)-1].Key, key) >= 0
+	})
+	if bufferIndex == n {
+		bufferIndex = n - 1
+	}
+	return bufferIndex
+}
+
+// findFunc returns buffer index and position of key in it
+func findFunc[K any, V any](buffers []*[]Entry[K, V], key K, cmp func(a, b K) int) (bufferIndex, index int, found bool) {
+	if len(buffers) == 0 {
+		return 0, 0, false
+	}
+
+	bufferIndex = findBufferFunc(buffers, key, cmp)
+	buffer := *buffers[bufferIndex]
+	index = sort.Search(len(buffer), func(i int) bool {
+		return cmp(buffer[i].Key, key) >= 0
+	})
+	return bufferIndex, index, index < len(buffer) && cmp(buffer[index].Key, key) == 0
+}
+
+type insertKind int
+
+const (
+	insertInPlace   insertKind = iota
+	insertNewBuffer            // key is greater than all keys of a full buffer
+	insertSplit                // full buffer is split at half first
+)
+
+// planInsert tells how to insert at position index into a buffer of n entries
+func planInsert(n, index int) (kind insertKind, half int) {
+	switch {
+	case n < maxSliceSize:
+		return insertInPlace, 0
+	case index == n:
+		return insertNewBuffer, 0
+	}
+	return insertSplit, n / 2
+}
+
+// insertAt inserts entry at position index of buffer bufferIndex returned by find or findFunc, splitting a full buffer
+func insertAt[K any, V any](buffers *[]*[]Entry[K, V], bufferIndex, index int, entry Entry[K, V]) {
+	if len(*buffers) == 0 {
+		*buffers = append(*buffers, &[]Entry[K, V]{entry})
+		return
+	}
+
+	buffer := (*buffers)[bufferIndex]
+	switch kind, half := planInsert(len(*buffer), index); kind {
+	case insertNewBuffer:
+		*buffers = slices.Insert(*buffers, bufferIndex+1, &[]Entry[K, V]{entry})
+		return
+	case insertSplit:
+		right := make([]Entry[K, V], len(*buffer)-half, maxSliceSize)
+		copy(right, (*buffer)[half:])
+		clear((*buffer)[half:])
+		*buffer = (*buffer)[:half]
+		*buffers = slices.Insert(*buffers, bufferIndex+1, &right)
+
+		if index > half {
+			buffer = &right
+			index -= half
+		}
+	}
+
+	*buffer = slices.Insert(*buffer, index, entry)
+}
+
+// removeAt removes entry at position index of buffer bufferIndex, empty buffer is removed too
+func removeAt[K any, V any](buffers *[]*[]Entry[K, V], bufferIndex, index int) {
+	buffer := (*buffers)[bufferIndex]
+	*buffer = slices.Delete(*buffer, index, index+1)
+	if len(*buffer) == 0 {
+		*buffers = slices.Delete(*buffers, bufferIndex, bufferIndex+1)
+	}
+}
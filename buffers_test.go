@@ -0,0 +1,50 @@
+package compactmap
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuffersFunc(t *testing.T) {
+	// reversed order, so operators of int keys would give wrong results
+	desc := func(a, b int) int { return b - a }
+
+	var buffers []*[]Entry[int, int]
+	ref := make(map[int]int)
+	for i := 0; i < 20000; i++ {
+		key := rand.Intn(5000)
+		bufferIndex, index, found := findFunc(buffers, key, desc)
+		_, exists := ref[key]
+		assert.Equal(t, exists, found)
+
+		if rand.Intn(3) == 0 {
+			if found {
+				removeAt(&buffers, bufferIndex, index)
+				delete(ref, key)
+			}
+			continue
+		}
+		if found {
+			(*buffers[bufferIndex])[index].Value = i
+		} else {
+			insertAt(&buffers, bufferIndex, index, Entry[int, int]{Key: key, Value: i})
+		}
+		ref[key] = i
+	}
+
+	count := 0
+	prev := 5000
+	for _, buffer := range buffers {
+		assert.True(t, len(*buffer) > 0 && len(*buffer) <= maxSliceSize, "Buffer size %d", len(*buffer))
+		for _, e := range *buffer {
+			assert.True(t, e.Key < prev, "Keys should be in descending order")
+			assert.Equal(t, ref[e.Key], e.Value)
+			prev = e.Key
+			count++
+		}
+	}
+	assert.Equal(t, len(ref), count)
+	assert.True(t, len(buffers) > 1, "Buffers should be split")
+}
@@ -0,0 +1,342 @@
+package compactmap
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/constraints"
+)
+
+/*
+	CompactBytesMap keeps []byte values in large slabs referenced by offset and length,
+	so buffers hold no pointers to values and the GC does not scan millions of small slices.
+	Slabs are append-only: deleted and overwritten values stay in slabs as garbage
+	until compaction copies live values into new slabs.
+*/
+
+const slabSize = 1 << 20
+
+// valueRef points to a value in slabs
+type valueRef struct {
+	slab   uint32
+	offset uint32
+	length uint32
+}
+
+type CompactBytesMap[K constraints.Ordered] struct {
+	sync.RWMutex
+
+	refs    *CompactMap[K, valueRef] // accessed under the lock of CompactBytesMap
+	slabs   [][]byte
+	live    int // bytes of stored values
+	garbage int // bytes of deleted and overwritten values
+
+	saveMu     sync.Mutex // one Save or Init at a time
+	loadedFile string
+	lastSave   time.Time
+}
+
+func NewCompactBytesMap[K constraints.Ordered]() *CompactBytesMap[K] {
+	return &CompactBytesMap[K]{refs: NewCompactMap[K, valueRef]()}
+}
+
+// alloc copies value into slabs
+func (m *CompactBytesMap[K]) alloc(value []byte) valueRef {
+	n := len(m.slabs)
+	if n == 0 || len(m.slabs[n-1])+len(value) > cap(m.slabs[n-1]) {
+		m.slabs = append(m.slabs, make([]byte, 0, max(slabSize, len(value))))
+		n++
+	}
+	slab := m.slabs[n-1]
+	ref := valueRef{slab: uint32(n - 1), offset: uint32(len(slab)), length: uint32(len(value))}
+	m.slabs[n-1] = append(slab, value...)
+	m.live += len(value)
+	return ref
+}
+
+func (m *CompactBytesMap[K]) value(ref valueRef) []byte {
+	end := ref.offset + ref.length
+	return m.slabs[ref.slab][ref.offset:end:end]
+}
+
+// Get returns value stored in a slab, it is valid after changes of the map but should not be modified
+func (m *CompactBytesMap[K]) Get(key K) ([]byte, bool) {
+	m.RLock()
+	defer m.RUnlock()
+
+	ref, found := m.refs.get(key)
+	if !found {
+		return nil, false
+	}
+	return m.value(ref), true
+}
+
+// alias map-compatible
+func (m *CompactBytesMap[K]) Load(key K) ([]byte, bool) {
+	return m.Get(key)
+}
+
+func (m *CompactBytesMap[K]) Exist(key K) bool {
+	m.RLock()
+	defer m.RUnlock()
+
+	_, _, found := m.refs.find(key)
+	return found
+}
+
+func (m *CompactBytesMap[K]) Count() int {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.refs.size
+}
+
+// sync.map - compatible
+func (m *CompactBytesMap[K]) Store(key K, value []byte) {
+	m.AddOrSet(key, value)
+}
+
+// AddOrSet copies value into slabs
+func (m *CompactBytesMap[K]) AddOrSet(key K, value []byte) (overwrited bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.addOrSet(key, value)
+}
+
+func (m *CompactBytesMap[K]) addOrSet(key K, value []byte) bool {
+	old, overwrited := m.refs.store(key, m.alloc(value))
+	if overwrited {
+		m.release(old)
+	}
+	return overwrited
+}
+
+func (m *CompactBytesMap[K]) Delete(key K) {
+	m.Lock()
+	defer m.Unlock()
+
+	ref, found := m.refs.get(key)
+	if !found {
+		return
+	}
+	m.refs.delete(key)
+	m.release(ref)
+}
+
+// release marks value as garbage and compacts slabs if garbage takes more than live values
+func (m *CompactBytesMap[K]) release(ref valueRef) {
+	m.live -= int(ref.length)
+	m.garbage += int(ref.length)
+	if m.garbage > slabSize && m.garbage > m.live {
+		m.compact()
+	}
+}
+
+func (m *CompactBytesMap[K]) Clear() {
+	m.Lock()
+	defer m.Unlock()
+
+	m.refs.clear()
+	m.slabs = nil
+	m.live = 0
+	m.garbage = 0
+}
+
+// Compact copies live values into new slabs, so memory of deleted values is freed
+func (m *CompactBytesMap[K]) Compact() {
+	m.Lock()
+	defer m.Unlock()
+
+	m.compact()
+}
+
+func (m *CompactBytesMap[K]) compact() {
+	old := m.slabs
+	m.slabs = nil
+	m.live = 0
+	m.garbage = 0
+
+	for _, buffer := range m.refs.buffers {
+		refs := *buffer
+		for i := range refs {
+			ref := refs[i].Value
+			end := ref.offset + ref.length
+			refs[i].Value = m.alloc(old[ref.slab][ref.offset:end])
+		}
+	}
+}
+
+// SlabBytes returns bytes of stored values and bytes allocated for slabs
+func (m *CompactBytesMap[K]) SlabBytes() (live, allocated int) {
+	m.RLock()
+	defer m.RUnlock()
+
+	for _, slab := range m.slabs {
+		allocated += cap(slab)
+	}
+	return m.live, allocated
+}
+
+// Iterate calls fn for entries in key order until fn returns false.
+// dont modify database in iterate!
+func (m *CompactBytesMap[K]) Iterate(fn func(key K, val []byte) bool) {
+	m.RLock()
+	defer m.RUnlock()
+
+	for c := newCursor(m.refs.buffers); c.valid(); c.next() {
+		if !fn(c.entry().Key, m.value(c.entry().Value)) {
+			return
+		}
+	}
+}
+
+// sync.Map alias
+func (m *CompactBytesMap[K]) Range(fn func(key K, val []byte) bool) {
+	m.Iterate(fn)
+}
+
+func (m *CompactBytesMap[K]) Stats() string {
+	m.RLock()
+	defer m.RUnlock()
+
+	return fmt.Sprintf("%d buffers, total len: %d, %d slabs, live: %d, garbage: %d",
+		len(m.refs.buffers), m.refs.size, len(m.slabs), m.live, m.garbage)
+}
+
+// chunk returns entries of i-th group of chunkBuffers buffers, values point into slabs
+func (m *CompactBytesMap[K]) chunk(i int) [][]Entry[K, []byte] {
+	buffers := m.refs.buffers[i*chunkBuffers : min(len(m.refs.buffers), (i+1)*chunkBuffers)]
+	chunk := make([][]Entry[K, []byte], len(buffers))
+	for j, buffer := range buffers {
+		entries := make([]Entry[K, []byte], len(*buffer))
+		for k, e := range *buffer {
+			entries[k] = Entry[K, []byte]{Key: e.Key, Value: m.value(e.Value)}
+		}
+		chunk[j] = entries
+	}
+	return chunk
+}
+
+// Save stores the map into filename in the format of CompactMap[K, []byte], see CompactMap.Save
+func (m *CompactBytesMap[K]) Save(filename string) error {
+	return m.SaveContext(context.Background(), filename, nil)
+}
+
+// SaveContext stores the map into filename, see CompactMap.SaveContext
+func (m *CompactBytesMap[K]) SaveContext(ctx context.Context, filename string, progress ProgressFunc) error {
+	m.saveMu.Lock()
+	defer m.saveMu.Unlock()
+
+	// changes made while the file is written mark the map changed again
+	m.Lock()
+	if m.loadedFile == filename && !m.refs.changed {
+		m.Unlock()
+		return nil
+	}
+	m.refs.changed = false
+	m.Unlock()
+
+	m.RLock()
+	n := (len(m.refs.buffers) + chunkBuffers - 1) / chunkBuffers
+	opts := m.refs.snapshotOptions(progress)
+	err := saveFile(filename, func(file *os.File) error {
+		return writeSnapshotFunc[K, []byte](ctx, file, n, m.chunk, nil, opts)
+	})
+	m.RUnlock()
+
+	m.Lock()
+	defer m.Unlock()
+
+	if err != nil {
+		m.refs.changed = true
+		return err
+	}
+	m.loadedFile = filename
+	m.lastSave = time.Now()
+	return nil
+}
+
+// Init loads entries stored by Save from filename, see CompactMap.Init
+func (m *CompactBytesMap[K]) Init(filename string) error {
+	return m.InitContext(context.Background(), filename, nil)
+}
+
+// InitContext loads entries stored by Save from filename, see CompactMap.InitContext.
+// Entries already expired are skipped, expiration times of the others are not kept.
+func (m *CompactBytesMap[K]) InitContext(ctx context.Context, filename string, progress ProgressFunc) error {
+	m.saveMu.Lock()
+	defer m.saveMu.Unlock()
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	st, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if !isSnapshot(file) {
+		return ErrBadSnapshot
+	}
+
+	m.RLock()
+	opts := m.refs.snapshotOptions(progress)
+	m.RUnlock()
+
+	loaded := NewCompactBytesMap[K]()
+	now := time.Now().UnixNano()
+	err = readSnapshot(ctx, file, st.Size(), opts, func(entries []Entry[K, []byte], expires []int64) {
+		for i, entry := range entries {
+			if expiredAt(expires, i, now) {
+				continue
+			}
+			loaded.addOrSet(entry.Key, entry.Value)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	if m.refs.size == 0 {
+		m.refs.buffers = loaded.refs.buffers
+		m.refs.size = loaded.refs.size
+		m.slabs = loaded.slabs
+		m.live = loaded.live
+		m.garbage = 0
+	} else {
+		for c := newCursor(loaded.refs.buffers); c.valid(); c.next() {
+			m.addOrSet(c.entry().Key, loaded.value(c.entry().Value))
+		}
+	}
+
+	m.refs.changed = false
+	m.loadedFile = filename
+	return nil
+}
+
+// SetWorkers sets how many goroutines encode and decode snapshot chunks, see CompactMap.SetWorkers
+func (m *CompactBytesMap[K]) SetWorkers(n int) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.refs.workers = n
+}
+
+// SetEncryption enables snapshot encryption, see CompactMap.SetEncryption
+func (m *CompactBytesMap[K]) SetEncryption(keys KeyProvider) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.refs.keys = keys
+	m.refs.changed = true
+}
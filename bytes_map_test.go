@@ -0,0 +1,142 @@
+package compactmap
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompactBytesMap(t *testing.T) {
+	cm := NewCompactBytesMap[int]()
+	assert.False(t, cm.AddOrSet(1, []byte("one")))
+	assert.False(t, cm.AddOrSet(2, []byte("two")))
+	assert.True(t, cm.AddOrSet(1, []byte("uno")))
+
+	v, ok := cm.Get(1)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("uno"), v)
+	assert.Equal(t, 3, cap(v))
+
+	value := []byte("three")
+	cm.AddOrSet(3, value)
+	value[0] = 'T'
+	v, _ = cm.Get(3)
+	assert.Equal(t, []byte("three"), v)
+
+	cm.Delete(2)
+	assert.False(t, cm.Exist(2))
+	assert.Equal(t, 2, cm.Count())
+
+	live, _ := cm.SlabBytes()
+	assert.Equal(t, 8, live)
+
+	big := bytes.Repeat([]byte{1}, 2*slabSize)
+	cm.AddOrSet(4, big)
+	v, _ = cm.Get(4)
+	assert.Equal(t, big, v)
+}
+
+func TestCompactBytesMapCompaction(t *testing.T) {
+	cm := NewCompactBytesMap[int]()
+	value := bytes.Repeat([]byte{'x'}, 1000)
+	for i := 0; i < 10000; i++ {
+		cm.AddOrSet(i, value)
+	}
+	first, _ := cm.Get(0)
+
+	for i := 0; i < 10000; i++ {
+		if i%10 != 0 {
+			cm.Delete(i)
+		}
+	}
+
+	live, allocated := cm.SlabBytes()
+	assert.Equal(t, 1000*1000, live)
+	assert.True(t, allocated <= 2*slabSize, allocated)
+
+	// slices returned before compaction stay valid
+	assert.Equal(t, value, first)
+
+	prev := -10
+	cm.Iterate(func(key int, val []byte) bool {
+		assert.Equal(t, prev+10, key)
+		assert.Equal(t, value, val)
+		prev = key
+		return true
+	})
+
+	cm.Compact()
+	live, allocated = cm.SlabBytes()
+	assert.Equal(t, 1000*1000, live)
+	assert.Equal(t, slabSize, allocated)
+}
+
+func TestCompactBytesMapSaveInit(t *testing.T) {
+	cm := NewCompactBytesMap[string]()
+	for i := 0; i < 3000; i++ {
+		cm.AddOrSet(fmt.Sprint(i), []byte(fmt.Sprint("value", i)))
+	}
+
+	file := filepath.Join(t.TempDir(), "bytes.dat")
+	assert.Nil(t, cm.Save(file))
+
+	loaded := NewCompactBytesMap[string]()
+	assert.Nil(t, loaded.Init(file))
+	assert.Equal(t, 3000, loaded.Count())
+	v, _ := loaded.Get("1234")
+	assert.Equal(t, []byte("value1234"), v)
+
+	// format is the same as of CompactMap[K, []byte]
+	plain := NewCompactMap[string, []byte]()
+	assert.Nil(t, plain.Init(file))
+	v, _ = plain.Get("1234")
+	assert.Equal(t, []byte("value1234"), v)
+}
+
+func TestCompactBytesMapInitSkipsExpired(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ttl.dat")
+	saveExpiring(t, file, func(i int) int { return i }, []byte("v"))
+
+	loaded := NewCompactBytesMap[int]()
+	assert.Nil(t, loaded.Init(file))
+	assert.Equal(t, 5, loaded.Count())
+	assert.True(t, loaded.Exist(2))
+	assert.False(t, loaded.Exist(3), "Expired key should not be loaded")
+}
+
+func TestCompactBytesMapConcurrentSave(t *testing.T) {
+	cm := NewCompactBytesMap[int]()
+	testConcurrentSave(t, func(i int) { cm.AddOrSet(i, []byte(fmt.Sprint(i))) }, cm.Save, func(file string) int {
+		loaded := NewCompactBytesMap[int]()
+		assert.NoError(t, loaded.Init(file))
+		return loaded.Count()
+	})
+}
+
+func BenchmarkGCCompactMapBytes(b *testing.B) {
+	cm := NewCompactMap[int, []byte]()
+	for i := 0; i < 1000000; i++ {
+		cm.AddOrSet(i, []byte("some value"))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+	}
+	runtime.KeepAlive(cm)
+}
+
+func BenchmarkGCCompactBytesMap(b *testing.B) {
+	cm := NewCompactBytesMap[int]()
+	for i := 0; i < 1000000; i++ {
+		cm.AddOrSet(i, []byte("some value"))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+	}
+	runtime.KeepAlive(cm)
+}
@@ -0,0 +1,120 @@
+package compactmap
+
+import (
+	"time"
+
+	"golang.org/x/exp/constraints"
+)
+
+// Clone returns a copy of the map with its own buffers.
+// copyValue, if not nil, copies values holding pointers, otherwise values are copied as is.
+// Expiration times, workers, encryption and logger settings are copied, subscribers and bounds are not.
+func (m *CompactMap[K, V]) Clone(copyValue func(V) V) *CompactMap[K, V] {
+	m.RLock()
+	defer m.RUnlock()
+
+	ret := m.clone(copyValue)
+	ret.workers = m.workers
+	ret.keys = m.keys
+	ret.logger = m.logger
+	if m.expires != nil {
+		ret.expires = m.expires.clone(nil)
+	}
+	return ret
+}
+
+func (m *CompactMap[K, V]) clone(copyValue func(V) V) *CompactMap[K, V] {
+	ret := NewCompactMap[K, V]()
+	for _, buffer := range m.buffers {
+		b := make([]Entry[K, V], len(*buffer), cap(*buffer))
+		copy(b, *buffer)
+		if copyValue != nil {
+			for i := range b {
+				b[i].Value = copyValue(b[i].Value)
+			}
+		}
+		ret.buffers = append(ret.buffers, &b)
+	}
+	ret.size = m.size
+	ret.changed = true
+	return ret
+}
+
+/*
+	FrozenMap is an immutable copy of CompactMap made by Freeze.
+	It is safe for concurrent use without locks.
+*/
+
+type FrozenMap[K constraints.Ordered, V any] struct {
+	m *CompactMap[K, V] // never modified, accessed without locks
+}
+
+// Freeze returns an immutable copy of the map without expired entries.
+// Values are copied as is, so values holding pointers should not be modified.
+func (m *CompactMap[K, V]) Freeze() *FrozenMap[K, V] {
+	m.RLock()
+	defer m.RUnlock()
+
+	frozen := NewCompactMap[K, V]()
+	checkExpired := m.expires != nil && len(m.expires.buffers) > 0
+	now := time.Now().UnixNano()
+	for c := newCursor(m.buffers); c.valid(); c.next() {
+		e := c.entry()
+		if checkExpired && m.isExpired(e.Key, now) {
+			continue
+		}
+		frozen.appendSorted(e.Key, e.Value)
+	}
+	return &FrozenMap[K, V]{m: frozen}
+}
+
+func (f *FrozenMap[K, V]) Get(key K) (V, bool) {
+	return f.m.get(key)
+}
+
+// alias map-compatible
+func (f *FrozenMap[K, V]) Load(key K) (V, bool) {
+	return f.m.get(key)
+}
+
+func (f *FrozenMap[K, V]) Exist(key K) bool {
+	_, _, found := f.m.find(key)
+	return found
+}
+
+func (f *FrozenMap[K, V]) Count() int {
+	return f.m.size
+}
+
+func (f *FrozenMap[K, V]) Iterate(fn func(key K, val V) bool) {
+	for c := newCursor(f.m.buffers); c.valid(); c.next() {
+		if !fn(c.entry().Key, c.entry().Value) {
+			return
+		}
+	}
+}
+
+// sync.Map alias
+func (f *FrozenMap[K, V]) Range(fn func(key K, val V) bool) {
+	f.Iterate(fn)
+}
+
+// IterateFrom calls fn for entries with key >= from in key order until fn returns false
+func (f *FrozenMap[K, V]) IterateFrom(from K, fn func(key K, val V) bool) {
+	f.m.iterateFrom(from, fn)
+}
+
+// IterateRange calls fn for entries with from <= key < to in key order until fn returns false
+func (f *FrozenMap[K, V]) IterateRange(from, to K, fn func(key K, val V) bool) {
+	f.m.iterateFrom(from, func(key K, val V) bool {
+		if key >= to {
+			return false
+		}
+		return fn(key, val)
+	})
+}
+
+// Thaw returns a new mutable map with entries of the frozen one
+func (f *FrozenMap[K, V]) Thaw() *CompactMap[K, V] {
+	return f.m.clone(nil)
+}
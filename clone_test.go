@@ -0,0 +1,86 @@
+package compactmap
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClone(t *testing.T) {
+	cm := NewCompactMap[int, *int]()
+	for i := 0; i < 2500; i++ {
+		v := i
+		cm.AddOrSet(i, &v)
+	}
+	cm.AddOrSetWithTTL(5000, nil, time.Hour)
+
+	shallow := cm.Clone(nil)
+	deep := cm.Clone(func(v *int) *int {
+		if v == nil {
+			return nil
+		}
+		c := *v
+		return &c
+	})
+	assert.Equal(t, 2501, deep.Count())
+	ttl, ok := deep.TTL(5000)
+	assert.True(t, ok)
+	assert.True(t, ttl > 0)
+
+	v, _ := cm.Get(1)
+	*v = 100
+	cm.Delete(2)
+	cm.AddOrSet(-1, nil)
+
+	v, _ = shallow.Get(1)
+	assert.Equal(t, 100, *v)
+	v, _ = deep.Get(1)
+	assert.Equal(t, 1, *v)
+	assert.True(t, deep.Exist(2))
+	assert.False(t, deep.Exist(-1))
+
+	deep.AddOrSet(2600, nil)
+	assert.False(t, cm.Exist(2600))
+}
+
+func TestFreeze(t *testing.T) {
+	cm := NewCompactMap[int, int]()
+	for i := 0; i < 3000; i++ {
+		cm.AddOrSet(i, i*2)
+	}
+	cm.AddOrSetWithTTL(-1, -1, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	frozen := cm.Freeze()
+	cm.Delete(10)
+	cm.AddOrSet(5000, 1)
+
+	assert.Equal(t, 3000, frozen.Count())
+	assert.False(t, frozen.Exist(-1))
+	v, ok := frozen.Get(10)
+	assert.True(t, ok)
+	assert.Equal(t, 20, v)
+	assert.False(t, frozen.Exist(5000))
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n := 0
+			frozen.IterateRange(100, 200, func(key, val int) bool {
+				n++
+				return true
+			})
+			assert.Equal(t, 100, n)
+		}()
+	}
+	wg.Wait()
+
+	thawed := frozen.Thaw()
+	thawed.Delete(0)
+	assert.True(t, frozen.Exist(0))
+	assert.Equal(t, 2999, thawed.Count())
+}
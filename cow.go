@@ -0,0 +1,355 @@
+package compactmap
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/exp/constraints"
+)
+
+/*
+	CompactMapCOW is a read optimised CompactMap: readers never take a lock.
+	The buffer directory is published via atomic.Pointer and published buffers are never modified,
+	writers copy the affected buffer and the directory and publish the new version.
+	Writes are slower than in CompactMap, so use it for read-mostly workloads.
+	Iterate sees the version of the map published when it started.
+*/
+
+type cowState[K constraints.Ordered, V any] struct {
+	buffers []*[]Entry[K, V] // immutable
+	size    int
+}
+
+type CompactMapCOW[K constraints.Ordered, V any] struct {
+	state atomic.Pointer[cowState[K, V]]
+
+	mu           sync.Mutex // serialises writers, guards fields below
+	version      uint64     // incremented by every change
+	savedVersion uint64     // version stored by the last Save or Init
+	loadedFile   string
+	lastSave     time.Time
+
+	workers int         // snapshot encoding goroutines, see SetWorkers
+	keys    KeyProvider // snapshot encryption, see SetEncryption
+}
+
+func NewCompactMapCOW[K constraints.Ordered, V any]() *CompactMapCOW[K, V] {
+	m := &CompactMapCOW[K, V]{}
+	m.state.Store(&cowState[K, V]{})
+	return m
+}
+
+func (m *CompactMapCOW[K, V]) Get(key K) (V, bool) {
+	st := m.state.Load()
+	bufferIndex, index, found := find(st.buffers, key)
+	if !found {
+		var zero V
+		return zero, false
+	}
+	return (*st.buffers[bufferIndex])[index].Value, true
+}
+
+// alias map-compatible
+func (m *CompactMapCOW[K, V]) Load(key K) (V, bool) {
+	return m.Get(key)
+}
+
+func (m *CompactMapCOW[K, V]) Exist(key K) bool {
+	_, _, found := find(m.state.Load().buffers, key)
+	return found
+}
+
+func (m *CompactMapCOW[K, V]) Count() int {
+	return m.state.Load().size
+}
+
+// Iterate calls fn for entries in key order until fn returns false.
+// The map may be modified in fn, changes are not seen by this iteration.
+func (m *CompactMapCOW[K, V]) Iterate(fn func(key K, val V) bool) {
+	for c := newCursor(m.state.Load().buffers); c.valid(); c.next() {
+		if !fn(c.entry().Key, c.entry().Value) {
+			return
+		}
+	}
+}
+
+// sync.Map alias
+func (m *CompactMapCOW[K, V]) Range(fn func(key K, val V) bool) {
+	m.Iterate(fn)
+}
+
+// IterateFrom calls fn for entries with key >= from in key order until fn returns false
+func (m *CompactMapCOW[K, V]) IterateFrom(from K, fn func(key K, val V) bool) {
+	buffers := m.state.Load().buffers
+	bufferIndex, index, _ := find(buffers, from)
+	c := &cursor[K, V]{buffers: buffers, b: bufferIndex, i: index}
+	if c.valid() && c.i >= len(*buffers[c.b]) {
+		c.b++
+		c.i = 0
+	}
+	for ; c.valid(); c.next() {
+		if !fn(c.entry().Key, c.entry().Value) {
+			return
+		}
+	}
+}
+
+// IterateRange calls fn for entries with from <= key < to in key order until fn returns false
+func (m *CompactMapCOW[K, V]) IterateRange(from, to K, fn func(key K, val V) bool) {
+	m.IterateFrom(from, func(key K, val V) bool {
+		if key >= to {
+			return false
+		}
+		return fn(key, val)
+	})
+}
+
+// sync.map - compatible
+func (m *CompactMapCOW[K, V]) Store(key K, value V) {
+	m.AddOrSet(key, value)
+}
+
+// Add or Set
+func (m *CompactMapCOW[K, V]) AddOrSet(key K, value V) (overwrited bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.set(key, value)
+}
+
+// sync.Map analog
+func (m *CompactMapCOW[K, V]) LoadOrStore(key K, value V) (old V, loaded bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if old, loaded = m.Get(key); loaded {
+		return old, true
+	}
+	m.set(key, value)
+	return value, false
+}
+
+func (m *CompactMapCOW[K, V]) LoadAndDelete(key K) (old V, loaded bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if old, loaded = m.Get(key); loaded {
+		m.delete(key)
+	}
+	return old, loaded
+}
+
+func (m *CompactMapCOW[K, V]) Delete(key K) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.delete(key)
+}
+
+func (m *CompactMapCOW[K, V]) Clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.state.Store(&cowState[K, V]{})
+	m.version++
+}
+
+// publish replaces buffers [from, to) of st with buffers, called under m.mu
+func (m *CompactMapCOW[K, V]) publish(st *cowState[K, V], from, to int, sizeDelta int, buffers ...*[]Entry[K, V]) {
+	m.state.Store(&cowState[K, V]{
+		buffers: slices.Concat(st.buffers[:from], buffers, st.buffers[to:]),
+		size:    st.size + sizeDelta,
+	})
+	m.version++
+}
+
+func (m *CompactMapCOW[K, V]) set(key K, value V) (overwrited bool) {
+	st := m.state.Load()
+	entry := Entry[K, V]{Key: key, Value: value}
+
+	if len(st.buffers) == 0 {
+		m.publish(st, 0, 0, 1, &[]Entry[K, V]{entry})
+		return false
+	}
+
+	bufferIndex, index, found := find(st.buffers, key)
+	buffer := *st.buffers[bufferIndex]
+	if found {
+		b := slices.Clone(buffer)
+		b[index].Value = value
+		m.publish(st, bufferIndex, bufferIndex+1, 0, &b)
+		return true
+	}
+
+	switch kind, half := planInsert(len(buffer), index); kind {
+	case insertNewBuffer:
+		m.publish(st, bufferIndex+1, bufferIndex+1, 1, &[]Entry[K, V]{entry})
+		return false
+	case insertSplit:
+		left := slices.Clone(buffer[:half])
+		right := slices.Clone(buffer[half:])
+		if index > half {
+			right = slices.Insert(right, index-half, entry)
+		} else {
+			left = slices.Insert(left, index, entry)
+		}
+		m.publish(st, bufferIndex, bufferIndex+1, 1, &left, &right)
+		return false
+	}
+
+	b := make([]Entry[K, V], len(buffer)+1)
+	copy(b, buffer[:index])
+	b[index] = entry
+	copy(b[index+1:], buffer[index:])
+	m.publish(st, bufferIndex, bufferIndex+1, 1, &b)
+	return false
+}
+
+func (m *CompactMapCOW[K, V]) delete(key K) {
+	st := m.state.Load()
+	bufferIndex, index, found := find(st.buffers, key)
+	if !found {
+		return
+	}
+
+	buffer := *st.buffers[bufferIndex]
+	if len(buffer) == 1 {
+		//remove whole slice
+		m.publish(st, bufferIndex, bufferIndex+1, -1)
+		return
+	}
+
+	b := make([]Entry[K, V], len(buffer)-1)
+	copy(b, buffer[:index])
+	copy(b[index:], buffer[index+1:])
+	m.publish(st, bufferIndex, bufferIndex+1, -1, &b)
+}
+
+// Stats returns buffer and entry counts without walking entries, see StatsInfo for details
+func (m *CompactMapCOW[K, V]) Stats() string {
+	state := m.state.Load()
+
+	return fmt.Sprintf("%d buffers, total len: %d", len(state.buffers), state.size)
+}
+
+// StatsInfo collects statistics of buffers and memory usage
+func (m *CompactMapCOW[K, V]) StatsInfo() StatsInfo {
+	info := statsOf(m.state.Load().buffers)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	info.Dirty = m.version != m.savedVersion
+	info.LastSave = m.lastSave
+	info.LoadedFile = m.loadedFile
+	return info
+}
+
+// SetWorkers sets how many goroutines encode and decode snapshot chunks, see CompactMap.SetWorkers
+func (m *CompactMapCOW[K, V]) SetWorkers(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.workers = n
+}
+
+// SetEncryption enables snapshot encryption, see CompactMap.SetEncryption
+func (m *CompactMapCOW[K, V]) SetEncryption(keys KeyProvider) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.keys = keys
+	m.version++
+}
+
+func (m *CompactMapCOW[K, V]) snapshotOptions(progress ProgressFunc) snapshotOptions {
+	return snapshotOptions{
+		workers:  workersOrDefault(m.workers),
+		progress: progress,
+		keys:     m.keys,
+	}
+}
+
+// Save stores the map into filename, see SaveContext
+func (m *CompactMapCOW[K, V]) Save(filename string) error {
+	return m.SaveContext(context.Background(), filename, nil)
+}
+
+// SaveContext stores the published version of the map into filename, see CompactMap.SaveContext.
+// Writers are not blocked while the file is written.
+func (m *CompactMapCOW[K, V]) SaveContext(ctx context.Context, filename string, progress ProgressFunc) error {
+	m.mu.Lock()
+	if m.loadedFile == filename && m.version == m.savedVersion {
+		m.mu.Unlock()
+		return nil
+	}
+	st := m.state.Load()
+	version := m.version
+	opts := m.snapshotOptions(progress)
+	m.mu.Unlock()
+
+	err := saveFile(filename, func(file *os.File) error {
+		return writeSnapshot[K, V](ctx, file, chunksOf(st.buffers), nil, opts)
+	})
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.savedVersion = version
+	m.loadedFile = filename
+	m.lastSave = time.Now()
+	return nil
+}
+
+// Init loads entries stored by Save from filename, see InitContext
+func (m *CompactMapCOW[K, V]) Init(filename string) error {
+	return m.InitContext(context.Background(), filename, nil)
+}
+
+// InitContext loads entries stored by Save from filename, see CompactMap.InitContext.
+// Expiration times stored in the file are not kept.
+func (m *CompactMapCOW[K, V]) InitContext(ctx context.Context, filename string, progress ProgressFunc) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	st, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	opts := m.snapshotOptions(progress)
+	m.mu.Unlock()
+
+	loaded, err := readMap[K, V](ctx, file, st.Size(), opts)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if cur := m.state.Load(); len(cur.buffers) == 0 {
+		// loaded map is not used anymore, its buffers can be published as is
+		m.publish(cur, 0, 0, loaded.size, loaded.buffers...)
+	} else {
+		for c := newCursor(loaded.buffers); c.valid(); c.next() {
+			m.set(c.entry().Key, c.entry().Value)
+		}
+	}
+
+	m.savedVersion = m.version
+	m.loadedFile = filename
+	return nil
+}
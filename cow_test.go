@@ -0,0 +1,165 @@
+package compactmap
+
+import (
+	"math/rand"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompactMapCOW(t *testing.T) {
+	cm := NewCompactMapCOW[int, int]()
+	const n = 5000
+	for _, i := range rand.Perm(n) {
+		assert.False(t, cm.AddOrSet(i, i))
+	}
+	assert.True(t, cm.AddOrSet(7, 70))
+	assert.Equal(t, n, cm.Count())
+
+	v, ok := cm.Get(7)
+	assert.True(t, ok)
+	assert.Equal(t, 70, v)
+
+	prev := -1
+	cm.Iterate(func(key, val int) bool {
+		assert.Equal(t, prev+1, key)
+		prev = key
+		return true
+	})
+	assert.Equal(t, n-1, prev)
+
+	for i := 0; i < n; i += 2 {
+		cm.Delete(i)
+	}
+	assert.Equal(t, n/2, cm.Count())
+	assert.False(t, cm.Exist(0))
+
+	var keys []int
+	cm.IterateRange(10, 20, func(key, val int) bool {
+		keys = append(keys, key)
+		return true
+	})
+	assert.Equal(t, []int{11, 13, 15, 17, 19}, keys)
+
+	old, loaded := cm.LoadAndDelete(11)
+	assert.True(t, loaded)
+	assert.Equal(t, 11, old)
+	_, loaded = cm.LoadOrStore(11, 12)
+	assert.False(t, loaded)
+
+	file := filepath.Join(t.TempDir(), "cow.dat")
+	assert.Nil(t, cm.Save(file))
+	assert.False(t, cm.StatsInfo().Dirty)
+
+	loadedMap := NewCompactMapCOW[int, int]()
+	assert.Nil(t, loadedMap.Init(file))
+	assert.Equal(t, cm.Count(), loadedMap.Count())
+	v, _ = loadedMap.Get(11)
+	assert.Equal(t, 12, v)
+}
+
+func TestCompactMapCOWSnapshotIteration(t *testing.T) {
+	cm := NewCompactMapCOW[int, int]()
+	for i := 0; i < 100; i++ {
+		cm.AddOrSet(i, i)
+	}
+
+	// changes made during iteration are not seen by it
+	n := 0
+	cm.Iterate(func(key, val int) bool {
+		cm.Delete(key + 1)
+		n++
+		return true
+	})
+	assert.Equal(t, 100, n)
+	assert.Equal(t, 1, cm.Count())
+}
+
+func TestCompactMapCOWConcurrent(t *testing.T) {
+	cm := NewCompactMapCOW[int, int]()
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				cm.AddOrSet(i*4+w, i)
+				cm.Get(i)
+				if i%10 == 0 {
+					cm.Iterate(func(key, val int) bool { return key < 100 })
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	assert.Equal(t, 8000, cm.Count())
+}
+
+const benchEntries = 100000
+
+func BenchmarkParallelGetCompactMap(b *testing.B) {
+	cm := NewCompactMap[int, int]()
+	for i := 0; i < benchEntries; i++ {
+		cm.AddOrSet(i, i)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(rand.Int63()))
+		for pb.Next() {
+			cm.Get(r.Intn(benchEntries))
+		}
+	})
+}
+
+func BenchmarkParallelGetCompactMapCOW(b *testing.B) {
+	cm := NewCompactMapCOW[int, int]()
+	for i := 0; i < benchEntries; i++ {
+		cm.AddOrSet(i, i)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(rand.Int63()))
+		for pb.Next() {
+			cm.Get(r.Intn(benchEntries))
+		}
+	})
+}
+
+// 1% of operations are writes
+func BenchmarkParallelMixedCompactMap(b *testing.B) {
+	cm := NewCompactMap[int, int]()
+	for i := 0; i < benchEntries; i++ {
+		cm.AddOrSet(i, i)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(rand.Int63()))
+		for pb.Next() {
+			if r.Intn(100) == 0 {
+				cm.AddOrSet(r.Intn(benchEntries), 0)
+			} else {
+				cm.Get(r.Intn(benchEntries))
+			}
+		}
+	})
+}
+
+func BenchmarkParallelMixedCompactMapCOW(b *testing.B) {
+	cm := NewCompactMapCOW[int, int]()
+	for i := 0; i < benchEntries; i++ {
+		cm.AddOrSet(i, i)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		r := rand.New(rand.NewSource(rand.Int63()))
+		for pb.Next() {
+			if r.Intn(100) == 0 {
+				cm.AddOrSet(r.Intn(benchEntries), 0)
+			} else {
+				cm.Get(r.Intn(benchEntries))
+			}
+		}
+	})
+}
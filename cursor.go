@@ -0,0 +1,79 @@
+package compactmap
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// cursor walks entries of sorted buffers in order
+type cursor[K any, V any] struct {
+	buffers []*[]Entry[K, V]
+	b, i    int
+}
+
+func newCursor[K any, V any](buffers []*[]Entry[K, V]) *cursor[K, V] {
+	return &cursor[K, V]{buffers: buffers}
+}
+
+func (c *cursor[K, V]) valid() bool {
+	return c.b < len(c.buffers)
+}
+
+func (c *cursor[K, V]) entry() Entry[K, V] {
+	return (*c.buffers[c.b])[c.i]
+}
+
+func (c *cursor[K, V]) next() {
+	c.i++
+	if c.i >= len(*c.buffers[c.b]) {
+		c.b++
+		c.i = 0
+	}
+}
+
+// appendSorted adds entry with key greater than all stored keys, filling buffers up to maxSliceSize.
+// Used to build maps from sorted sequences.
+func (m *CompactMap[K, V]) appendSorted(key K, value V) {
+	n := len(m.buffers)
+	if n == 0 || len(*m.buffers[n-1]) >= maxSliceSize {
+		buffer := make([]Entry[K, V], 0, maxSliceSize)
+		m.buffers = append(m.buffers, &buffer)
+		n++
+	}
+	*m.buffers[n-1] = append(*m.buffers[n-1], Entry[K, V]{Key: key, Value: value})
+	m.size++
+	m.changed = true
+	m.mutations.Add(1)
+}
+
+// rlockPair read-locks two maps in address order, so concurrent calls with swapped arguments do not deadlock
+func rlockPair(a, b *sync.RWMutex) (unlock func()) {
+	if a == b {
+		a.RLock()
+		return a.RUnlock
+	}
+	if uintptr(unsafe.Pointer(a)) > uintptr(unsafe.Pointer(b)) {
+		a, b = b, a
+	}
+	a.RLock()
+	b.RLock()
+	return func() {
+		b.RUnlock()
+		a.RUnlock()
+	}
+}
+
+// lockPair write-locks w and read-locks r in address order, see rlockPair
+func lockPair(w, r *sync.RWMutex) (unlock func()) {
+	if uintptr(unsafe.Pointer(w)) < uintptr(unsafe.Pointer(r)) {
+		w.Lock()
+		r.RLock()
+	} else {
+		r.RLock()
+		w.Lock()
+	}
+	return func() {
+		w.Unlock()
+		r.RUnlock()
+	}
+}
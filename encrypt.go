@@ -0,0 +1,125 @@
+package compactmap
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrEncrypted    = errors.New("compactmap: snapshot is encrypted, no key provider set")
+	ErrNotEncrypted = errors.New("compactmap: snapshot is not encrypted, but key provider is set")
+	ErrTampered     = errors.New("compactmap: snapshot authentication failed")
+)
+
+// KeyProvider supplies AES keys (16, 24 or 32 bytes) for snapshot encryption.
+// Id of the key used by Save is stored in the file, so keys can be rotated:
+// Init asks for the key by that id.
+type KeyProvider interface {
+	// CurrentKey returns the key for new snapshots and its id
+	CurrentKey() (id string, key []byte, err error)
+	// Key returns the key with the given id
+	Key(id string) ([]byte, error)
+}
+
+type staticKey []byte
+
+func (k staticKey) CurrentKey() (string, []byte, error) {
+	return "", k, nil
+}
+
+func (k staticKey) Key(id string) ([]byte, error) {
+	if id != "" {
+		return nil, fmt.Errorf("compactmap: unknown key id %q", id)
+	}
+	return k, nil
+}
+
+// StaticKey returns KeyProvider with the single key
+func StaticKey(key []byte) KeyProvider {
+	return staticKey(key)
+}
+
+// SetEncryption enables AES-GCM encryption of snapshots written by Save
+// and requires Init to read encrypted snapshots only. nil disables encryption.
+// Every chunk is authenticated, so corrupted or tampered files fail to load with ErrTampered.
+func (m *CompactMap[K, V]) SetEncryption(keys KeyProvider) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.keys = keys
+	m.changed = true
+	m.mutations.Add(1)
+}
+
+// snapshotCipher seals snapshot chunks and index.
+// Header of the file with its random id is authenticated with every chunk,
+// so chunks can not be moved between snapshots made with the same key.
+// The index is authenticated with the trailer.
+type snapshotCipher struct {
+	aead   cipher.AEAD
+	header []byte
+}
+
+func newSnapshotCipher(key []byte, header []byte) (*snapshotCipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshotCipher{aead: aead, header: header}, nil
+}
+
+// additional data: header, kind of the part and its number or the trailer
+func (c *snapshotCipher) additional(kind byte, extra []byte) []byte {
+	ad := make([]byte, 0, len(c.header)+1+len(extra))
+	ad = append(ad, c.header...)
+	ad = append(ad, kind)
+	return append(ad, extra...)
+}
+
+func (c *snapshotCipher) seal(data, ad []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(data)+c.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, data, ad), nil
+}
+
+func (c *snapshotCipher) open(data, ad []byte) ([]byte, error) {
+	if len(data) < c.aead.NonceSize() {
+		return nil, ErrTampered
+	}
+	nonce := data[:c.aead.NonceSize()]
+	ret, err := c.aead.Open(nil, nonce, data[c.aead.NonceSize():], ad)
+	if err != nil {
+		return nil, ErrTampered
+	}
+	return ret, nil
+}
+
+func (c *snapshotCipher) sealChunk(n int, data []byte) ([]byte, error) {
+	return c.seal(data, c.additional('c', binary.LittleEndian.AppendUint32(nil, uint32(n))))
+}
+
+func (c *snapshotCipher) openChunk(n int, data []byte) ([]byte, error) {
+	ret, err := c.open(data, c.additional('c', binary.LittleEndian.AppendUint32(nil, uint32(n))))
+	if err != nil {
+		return nil, fmt.Errorf("%w: chunk %d", err, n)
+	}
+	return ret, nil
+}
+
+func (c *snapshotCipher) sealIndex(data, trailer []byte) ([]byte, error) {
+	return c.seal(data, c.additional('i', trailer))
+}
+
+func (c *snapshotCipher) openIndex(data, trailer []byte) ([]byte, error) {
+	return c.open(data, c.additional('i', trailer))
+}
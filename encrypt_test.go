@@ -0,0 +1,163 @@
+package compactmap
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type rotatingKeys struct {
+	current string
+	keys    map[string][]byte
+}
+
+func (r *rotatingKeys) CurrentKey() (string, []byte, error) {
+	return r.current, r.keys[r.current], nil
+}
+
+func (r *rotatingKeys) Key(id string) ([]byte, error) {
+	key, ok := r.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("no key %q", id)
+	}
+	return key, nil
+}
+
+func TestEncryptedSaveInit(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "enc.dat")
+	key := bytes.Repeat([]byte{7}, 32)
+
+	m := NewCompactMap[int, string]()
+	m.SetEncryption(StaticKey(key))
+	for i := 0; i < 50000; i++ {
+		m.AddOrSet(i, fmt.Sprintf("secret %d", i))
+	}
+	assert.Nil(t, m.Save(file))
+
+	data, err := os.ReadFile(file)
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(data, []byte("secret")), "values should not be stored in plain text")
+
+	m2 := NewCompactMap[int, string]()
+	m2.SetEncryption(StaticKey(key))
+	assert.Nil(t, m2.Init(file))
+	assert.Equal(t, 50000, m2.Count())
+	v, ok := m2.Get(4242)
+	assert.True(t, ok)
+	assert.Equal(t, "secret 4242", v)
+
+	// no key
+	m3 := NewCompactMap[int, string]()
+	assert.ErrorIs(t, m3.Init(file), ErrEncrypted)
+
+	// wrong key
+	m3.SetEncryption(StaticKey(bytes.Repeat([]byte{8}, 32)))
+	assert.ErrorIs(t, m3.Init(file), ErrTampered)
+	assert.Equal(t, 0, m3.Count())
+}
+
+func TestEncryptedTampering(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "enc.dat")
+	keys := StaticKey(bytes.Repeat([]byte{1}, 16))
+
+	m := NewCompactMap[int, int]()
+	m.SetEncryption(keys)
+	for i := 0; i < 40000; i++ {
+		m.AddOrSet(i, i)
+	}
+	assert.Nil(t, m.Save(file))
+
+	data, err := os.ReadFile(file)
+	assert.Nil(t, err)
+
+	for _, pos := range []int{snapshotHeaderSize + 2 + 5, snapshotHeaderSize + 2 + snapshotFileIDSize + 20, len(data) / 2, len(data) - snapshotTrailerSize - 10} {
+		bad := bytes.Clone(data)
+		bad[pos] ^= 1
+		assert.Nil(t, os.WriteFile(file, bad, 0644))
+
+		m2 := NewCompactMap[int, int]()
+		m2.SetEncryption(keys)
+		assert.ErrorIs(t, m2.Init(file), ErrTampered, "byte %d", pos)
+	}
+
+	// clearing encrypted flag
+	bad := bytes.Clone(data)
+	bad[8] = 0
+	assert.Nil(t, os.WriteFile(file, bad, 0644))
+	m2 := NewCompactMap[int, int]()
+	m2.SetEncryption(keys)
+	assert.ErrorIs(t, m2.Init(file), ErrNotEncrypted)
+
+	// plain snapshot is rejected when encryption is required
+	plain := NewCompactMap[int, int]()
+	plain.AddOrSet(1, 1)
+	assert.Nil(t, plain.Save(file))
+	assert.ErrorIs(t, m2.Init(file), ErrNotEncrypted)
+}
+
+func TestEncryptedChunkSwap(t *testing.T) {
+	dir := t.TempDir()
+	keys := StaticKey(bytes.Repeat([]byte{3}, 32))
+
+	// two snapshots with the same layout and different values
+	files := make([]string, 2)
+	for n, prefix := range []string{"a", "b"} {
+		m := NewCompactMap[int, string]()
+		m.SetEncryption(keys)
+		for i := 0; i < 40000; i++ {
+			m.AddOrSet(i, fmt.Sprintf("%s%05d", prefix, i))
+		}
+		files[n] = filepath.Join(dir, prefix+".dat")
+		assert.Nil(t, m.Save(files[n]))
+	}
+
+	a, err := os.ReadFile(files[0])
+	assert.Nil(t, err)
+	b, err := os.ReadFile(files[1])
+	assert.Nil(t, err)
+	assert.Equal(t, len(a), len(b))
+
+	header, _, aead, err := readSnapshotHeader(bytes.NewReader(a), int64(len(a)), keys)
+	assert.Nil(t, err)
+	index, err := readSnapshotIndex(bytes.NewReader(a), int64(len(a)), len(header), aead)
+	assert.Nil(t, err)
+	assert.True(t, len(index) > 1)
+
+	// chunk 1 of b put into a at the same place
+	ci := index[1]
+	copy(a[ci.offset:ci.offset+uint64(ci.size)], b[ci.offset:ci.offset+uint64(ci.size)])
+	assert.Nil(t, os.WriteFile(files[0], a, 0644))
+
+	m := NewCompactMap[int, string]()
+	m.SetEncryption(keys)
+	assert.ErrorIs(t, m.Init(files[0]), ErrTampered)
+	assert.Equal(t, 0, m.Count())
+}
+
+func TestEncryptedKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	keys := &rotatingKeys{current: "k1", keys: map[string][]byte{
+		"k1": bytes.Repeat([]byte{1}, 32),
+		"k2": bytes.Repeat([]byte{2}, 32),
+	}}
+
+	m := NewCompactMap[string, int]()
+	m.SetEncryption(keys)
+	m.AddOrSet("a", 1)
+	assert.Nil(t, m.Save(filepath.Join(dir, "old.dat")))
+
+	keys.current = "k2"
+	m.AddOrSet("b", 2)
+	assert.Nil(t, m.Save(filepath.Join(dir, "new.dat")))
+
+	for name, count := range map[string]int{"old.dat": 1, "new.dat": 2} {
+		m2 := NewCompactMap[string, int]()
+		m2.SetEncryption(keys)
+		assert.Nil(t, m2.Init(filepath.Join(dir, name)))
+		assert.Equal(t, count, m2.Count())
+	}
+}
@@ -0,0 +1,370 @@
+package compactmap
+
+import (
+	"container/heap"
+	"container/list"
+	"math/rand"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/exp/constraints"
+)
+
+// EvictionPolicy chooses entries to remove from a bounded map, see SetBounds.
+// Added, Removed and Victim are called under the map write lock,
+// Accessed is called by readers and may run concurrently with other methods.
+type EvictionPolicy[K constraints.Ordered] interface {
+	Added(key K)       // new key stored
+	Accessed(key K)    // existing key read or overwritten
+	Removed(key K)     // key deleted
+	Victim() (K, bool) // key to evict next
+}
+
+// Bounds limits size of the map. Zero limit means no limit.
+type Bounds[K constraints.Ordered, V any] struct {
+	MaxEntries int
+	MaxBytes   int64
+
+	// Size estimates memory used by an entry,
+	// size of the Entry struct is used if not set
+	Size func(key K, value V) int64
+
+	// Policy chooses entries to evict, LRU if not set
+	Policy EvictionPolicy[K]
+
+	// OnEvict is called under the map lock for every evicted entry,
+	// it should not call methods of the map
+	OnEvict func(key K, value V)
+}
+
+type bounded[K constraints.Ordered, V any] struct {
+	Bounds[K, V]
+	bytes int64 // estimated size of all entries
+}
+
+// SetBounds limits the map by entries count and/or estimated size in bytes.
+// Entries over the limits are evicted immediately and after every insert.
+// Zero Bounds removes limits.
+func (m *CompactMap[K, V]) SetBounds(b Bounds[K, V]) {
+	m.Lock()
+	defer m.unlock()
+
+	if b.MaxEntries <= 0 && b.MaxBytes <= 0 {
+		m.bounds = nil
+		return
+	}
+
+	if b.Size == nil {
+		size := int64(unsafe.Sizeof(Entry[K, V]{}))
+		b.Size = func(K, V) int64 { return size }
+	}
+	if b.Policy == nil {
+		b.Policy = NewLRU[K]()
+	}
+
+	m.bounds = &bounded[K, V]{Bounds: b}
+	m.trackAll()
+}
+
+// trackAll registers all entries in the policy and evicts entries over bounds
+func (m *CompactMap[K, V]) trackAll() {
+	for _, buffer := range m.buffers {
+		for _, e := range *buffer {
+			m.bounds.Policy.Added(e.Key)
+			m.bounds.bytes += m.bounds.Size(e.Key, e.Value)
+		}
+	}
+	m.evict()
+}
+
+// EstimatedBytes returns estimated size of entries of a bounded map
+func (m *CompactMap[K, V]) EstimatedBytes() int64 {
+	m.RLock()
+	defer m.RUnlock()
+
+	if m.bounds == nil {
+		return 0
+	}
+	return m.bounds.bytes
+}
+
+func (m *CompactMap[K, V]) overBounds() bool {
+	b := m.bounds
+	return (b.MaxEntries > 0 && m.count() > b.MaxEntries) ||
+		(b.MaxBytes > 0 && b.bytes > b.MaxBytes)
+}
+
+// makeRoom evicts entries before key is stored, so the new key is not evicted by its own insertion
+func (m *CompactMap[K, V]) makeRoom(key K, value V) {
+	b := m.bounds
+	need := b.Size(key, value)
+	old, exists := m.peek(key)
+	if exists {
+		need -= b.Size(key, old)
+	}
+
+	for (b.MaxEntries > 0 && !exists && m.count()+1 > b.MaxEntries) ||
+		(b.MaxBytes > 0 && b.bytes+need > b.MaxBytes) {
+		victim, ok := b.Policy.Victim()
+		if !ok || victim == key {
+			return
+		}
+		m.evictKey(victim)
+	}
+}
+
+// evict removes entries until the map fits in bounds
+func (m *CompactMap[K, V]) evict() {
+	for m.bounds != nil && m.overBounds() {
+		key, ok := m.bounds.Policy.Victim()
+		if !ok {
+			return
+		}
+		m.evictKey(key)
+	}
+}
+
+func (m *CompactMap[K, V]) evictKey(key K) {
+	value, found := m.peek(key)
+	if !found {
+		// policy is out of sync, forget the key
+		m.bounds.Policy.Removed(key)
+		return
+	}
+	m.delete(key)
+	if m.bounds.OnEvict != nil {
+		m.bounds.OnEvict(key, value)
+	}
+}
+
+// peek returns stored value ignoring expiration and without access tracking
+func (m *CompactMap[K, V]) peek(key K) (V, bool) {
+	bufferIndex, index, found := m.find(key)
+	if !found {
+		var zero V
+		return zero, false
+	}
+	return (*m.buffers[bufferIndex])[index].Value, true
+}
+
+// LRU evicts the least recently used key
+
+type lruPolicy[K constraints.Ordered] struct {
+	sync.Mutex
+	order *list.List // front is the most recently used
+	items map[K]*list.Element
+}
+
+// NewLRU returns policy evicting the least recently used keys
+func NewLRU[K constraints.Ordered]() EvictionPolicy[K] {
+	return &lruPolicy[K]{order: list.New(), items: make(map[K]*list.Element)}
+}
+
+func (p *lruPolicy[K]) Added(key K) {
+	p.Lock()
+	defer p.Unlock()
+
+	if el, ok := p.items[key]; ok {
+		p.order.MoveToFront(el)
+		return
+	}
+	p.items[key] = p.order.PushFront(key)
+}
+
+func (p *lruPolicy[K]) Accessed(key K) {
+	p.Lock()
+	defer p.Unlock()
+
+	if el, ok := p.items[key]; ok {
+		p.order.MoveToFront(el)
+	}
+}
+
+func (p *lruPolicy[K]) Removed(key K) {
+	p.Lock()
+	defer p.Unlock()
+
+	if el, ok := p.items[key]; ok {
+		p.order.Remove(el)
+		delete(p.items, key)
+	}
+}
+
+func (p *lruPolicy[K]) Victim() (K, bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	el := p.order.Back()
+	if el == nil {
+		var zero K
+		return zero, false
+	}
+	return el.Value.(K), true
+}
+
+// FIFO evicts the oldest added key
+
+type fifoPolicy[K constraints.Ordered] struct {
+	lruPolicy[K]
+}
+
+// NewFIFO returns policy evicting keys in order they were added
+func NewFIFO[K constraints.Ordered]() EvictionPolicy[K] {
+	return &fifoPolicy[K]{lruPolicy[K]{order: list.New(), items: make(map[K]*list.Element)}}
+}
+
+func (p *fifoPolicy[K]) Added(key K) {
+	p.Lock()
+	defer p.Unlock()
+
+	if _, ok := p.items[key]; !ok {
+		p.items[key] = p.order.PushFront(key)
+	}
+}
+
+func (p *fifoPolicy[K]) Accessed(key K) {}
+
+// LFU evicts the least frequently used key, the oldest one among equal
+
+type lfuItem[K constraints.Ordered] struct {
+	key   K
+	count uint64
+	seq   uint64 // insertion order for ties
+	index int
+}
+
+type lfuHeap[K constraints.Ordered] []*lfuItem[K]
+
+func (h lfuHeap[K]) Len() int { return len(h) }
+func (h lfuHeap[K]) Less(i, j int) bool {
+	if h[i].count != h[j].count {
+		return h[i].count < h[j].count
+	}
+	return h[i].seq < h[j].seq
+}
+func (h lfuHeap[K]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *lfuHeap[K]) Push(x any) {
+	item := x.(*lfuItem[K])
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+func (h *lfuHeap[K]) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return item
+}
+
+type lfuPolicy[K constraints.Ordered] struct {
+	sync.Mutex
+	heap  lfuHeap[K]
+	items map[K]*lfuItem[K]
+	seq   uint64
+}
+
+// NewLFU returns policy evicting the least frequently used keys
+func NewLFU[K constraints.Ordered]() EvictionPolicy[K] {
+	return &lfuPolicy[K]{items: make(map[K]*lfuItem[K])}
+}
+
+func (p *lfuPolicy[K]) Added(key K) {
+	p.Lock()
+	defer p.Unlock()
+
+	if item, ok := p.items[key]; ok {
+		item.count++
+		heap.Fix(&p.heap, item.index)
+		return
+	}
+	p.seq++
+	item := &lfuItem[K]{key: key, count: 1, seq: p.seq}
+	p.items[key] = item
+	heap.Push(&p.heap, item)
+}
+
+func (p *lfuPolicy[K]) Accessed(key K) {
+	p.Lock()
+	defer p.Unlock()
+
+	if item, ok := p.items[key]; ok {
+		item.count++
+		heap.Fix(&p.heap, item.index)
+	}
+}
+
+func (p *lfuPolicy[K]) Removed(key K) {
+	p.Lock()
+	defer p.Unlock()
+
+	if item, ok := p.items[key]; ok {
+		heap.Remove(&p.heap, item.index)
+		delete(p.items, key)
+	}
+}
+
+func (p *lfuPolicy[K]) Victim() (K, bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	if len(p.heap) == 0 {
+		var zero K
+		return zero, false
+	}
+	return p.heap[0].key, true
+}
+
+// Random evicts a random key
+
+type randomPolicy[K constraints.Ordered] struct {
+	sync.Mutex
+	keys  []K
+	index map[K]int
+}
+
+// NewRandomEviction returns policy evicting random keys
+func NewRandomEviction[K constraints.Ordered]() EvictionPolicy[K] {
+	return &randomPolicy[K]{index: make(map[K]int)}
+}
+
+func (p *randomPolicy[K]) Added(key K) {
+	p.Lock()
+	defer p.Unlock()
+
+	if _, ok := p.index[key]; !ok {
+		p.index[key] = len(p.keys)
+		p.keys = append(p.keys, key)
+	}
+}
+
+func (p *randomPolicy[K]) Accessed(key K) {}
+
+func (p *randomPolicy[K]) Removed(key K) {
+	p.Lock()
+	defer p.Unlock()
+
+	i, ok := p.index[key]
+	if !ok {
+		return
+	}
+	last := len(p.keys) - 1
+	p.keys[i] = p.keys[last]
+	p.index[p.keys[i]] = i
+	p.keys = p.keys[:last]
+	delete(p.index, key)
+}
+
+func (p *randomPolicy[K]) Victim() (K, bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	if len(p.keys) == 0 {
+		var zero K
+		return zero, false
+	}
+	return p.keys[rand.Intn(len(p.keys))], true
+}
@@ -0,0 +1,114 @@
+package compactmap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvictionLRU(t *testing.T) {
+	cm := NewCompactMap[int, int]()
+
+	var evicted []int
+	cm.SetBounds(Bounds[int, int]{
+		MaxEntries: 3,
+		OnEvict: func(key, value int) {
+			evicted = append(evicted, key)
+		},
+	})
+
+	cm.AddOrSet(1, 100)
+	cm.AddOrSet(2, 200)
+	cm.AddOrSet(3, 300)
+	cm.Get(1) // 2 is the least recently used now
+	cm.AddOrSet(4, 400)
+
+	assert.Equal(t, []int{2}, evicted)
+	assert.Equal(t, 3, cm.Count())
+	assert.False(t, cm.Exist(2))
+
+	cm.AddOrSet(3, 301) // overwrite counts as access
+	cm.AddOrSet(5, 500)
+	assert.Equal(t, []int{2, 1}, evicted)
+}
+
+func TestEvictionFIFO(t *testing.T) {
+	cm := NewCompactMap[int, int]()
+	cm.SetBounds(Bounds[int, int]{MaxEntries: 2, Policy: NewFIFO[int]()})
+
+	cm.AddOrSet(1, 100)
+	cm.AddOrSet(2, 200)
+	cm.Get(1)
+	cm.AddOrSet(3, 300)
+
+	assert.False(t, cm.Exist(1), "FIFO should evict the first added key")
+	assert.True(t, cm.Exist(2))
+	assert.True(t, cm.Exist(3))
+}
+
+func TestEvictionLFU(t *testing.T) {
+	cm := NewCompactMap[int, int]()
+	cm.SetBounds(Bounds[int, int]{MaxEntries: 3, Policy: NewLFU[int]()})
+
+	cm.AddOrSet(1, 100)
+	cm.AddOrSet(2, 200)
+	cm.AddOrSet(3, 300)
+	for i := 0; i < 3; i++ {
+		cm.Get(1)
+		cm.Get(3)
+	}
+	cm.Get(2)
+	cm.AddOrSet(4, 400)
+
+	assert.False(t, cm.Exist(2), "LFU should evict the least frequently used key")
+	assert.Equal(t, 3, cm.Count())
+}
+
+func TestEvictionRandom(t *testing.T) {
+	cm := NewCompactMap[int, int]()
+	cm.SetBounds(Bounds[int, int]{MaxEntries: 100, Policy: NewRandomEviction[int]()})
+
+	for i := 0; i < 5000; i++ {
+		cm.AddOrSet(i, i)
+	}
+	assert.Equal(t, 100, cm.Count())
+	cm.Delete(4999)
+	assert.Equal(t, 99, cm.Count())
+}
+
+func TestEvictionMaxBytes(t *testing.T) {
+	cm := NewCompactMap[int, string]()
+	for i := 0; i < 10; i++ {
+		cm.AddOrSet(i, "0123456789")
+	}
+
+	evicted := 0
+	cm.SetBounds(Bounds[int, string]{
+		MaxBytes: 55,
+		Size: func(key int, value string) int64 {
+			return int64(len(value))
+		},
+		OnEvict: func(key int, value string) {
+			evicted++
+		},
+	})
+	assert.Equal(t, 5, cm.Count(), "Entries over limit should be evicted by SetBounds")
+	assert.Equal(t, 5, evicted)
+	assert.Equal(t, int64(50), cm.EstimatedBytes())
+
+	cm.AddOrSet(100, "01234567890123456789")
+	assert.Equal(t, 4, cm.Count())
+	assert.Equal(t, int64(50), cm.EstimatedBytes())
+
+	cm.Delete(100)
+	assert.Equal(t, int64(30), cm.EstimatedBytes())
+
+	cm.Clear()
+	assert.Equal(t, int64(0), cm.EstimatedBytes())
+
+	cm.SetBounds(Bounds[int, string]{})
+	for i := 0; i < 10; i++ {
+		cm.AddOrSet(i, "0123456789")
+	}
+	assert.Equal(t, 10, cm.Count(), "Zero bounds should remove limits")
+}
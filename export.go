@@ -0,0 +1,244 @@
+package compactmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"golang.org/x/exp/constraints"
+)
+
+type FormatKind int
+
+const (
+	FormatJSONL FormatKind = iota + 1 // one {"key": ..., "value": ...} object per line
+	FormatCSV                         // header row and one row per entry
+)
+
+// Format of Export and Import
+type Format struct {
+	Kind FormatKind
+
+	// names of key and value fields of JSONL objects or columns of CSV header,
+	// "key" and "value" if empty. Import ignores other fields and columns.
+	KeyColumn   string
+	ValueColumn string
+}
+
+var (
+	JSONL = Format{Kind: FormatJSONL}
+	CSV   = Format{Kind: FormatCSV}
+)
+
+func (f Format) columns() (key, value string) {
+	key, value = f.KeyColumn, f.ValueColumn
+	if key == "" {
+		key = "key"
+	}
+	if value == "" {
+		value = "value"
+	}
+	return key, value
+}
+
+// ImportError tells the line of input which failed to import
+type ImportError struct {
+	Line int
+	Err  error
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("compactmap: import line %d: %v", e.Line, e.Err)
+}
+
+func (e *ImportError) Unwrap() error {
+	return e.Err
+}
+
+// Export writes entries in key order, expired entries are skipped.
+// CSV cells hold strings as is and other values as JSON.
+func (m *CompactMap[K, V]) Export(w io.Writer, format Format) error {
+	m.RLock()
+	defer m.RUnlock()
+
+	keyColumn, valueColumn := format.columns()
+	checkExpired := m.expires != nil && len(m.expires.buffers) > 0
+	now := time.Now().UnixNano()
+
+	switch format.Kind {
+	case FormatJSONL:
+		writer := bufio.NewWriter(w)
+		for c := newCursor(m.buffers); c.valid(); c.next() {
+			e := c.entry()
+			if checkExpired && m.isExpired(e.Key, now) {
+				continue
+			}
+			line, err := json.Marshal(map[string]any{keyColumn: e.Key, valueColumn: e.Value})
+			if err != nil {
+				return err
+			}
+			writer.Write(line)
+			writer.WriteByte('\n')
+		}
+		return writer.Flush()
+
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write([]string{keyColumn, valueColumn}); err != nil {
+			return err
+		}
+		for c := newCursor(m.buffers); c.valid(); c.next() {
+			e := c.entry()
+			if checkExpired && m.isExpired(e.Key, now) {
+				continue
+			}
+			key, err := formatCell(e.Key)
+			if err != nil {
+				return err
+			}
+			value, err := formatCell(e.Value)
+			if err != nil {
+				return err
+			}
+			if err := writer.Write([]string{key, value}); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+	return fmt.Errorf("compactmap: unknown format %d", format.Kind)
+}
+
+// Import reads entries written by Export or by hand and adds them to the map.
+// Input is read as a stream, entries are added only if the whole input is valid.
+// Every line or row should have both the key and the value, errors of bad lines are *ImportError.
+func (m *CompactMap[K, V]) Import(r io.Reader, format Format) error {
+	keyColumn, valueColumn := format.columns()
+	loaded := NewCompactMap[K, V]()
+
+	switch format.Kind {
+	case FormatJSONL:
+		reader := bufio.NewReader(r)
+		for line := 1; ; line++ {
+			data, err := reader.ReadBytes('\n')
+			if len(bytes.TrimSpace(data)) > 0 {
+				if err := importJSONL(loaded, data, keyColumn, valueColumn); err != nil {
+					return &ImportError{Line: line, Err: err}
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+	case FormatCSV:
+		reader := csv.NewReader(r)
+		reader.ReuseRecord = true
+		header, err := reader.Read()
+		if err != nil {
+			return &ImportError{Line: 1, Err: err}
+		}
+		keyIndex, valueIndex := -1, -1
+		for i, name := range header {
+			switch name {
+			case keyColumn:
+				keyIndex = i
+			case valueColumn:
+				valueIndex = i
+			}
+		}
+		if keyIndex < 0 || valueIndex < 0 {
+			return &ImportError{Line: 1, Err: fmt.Errorf("no %q or %q column", keyColumn, valueColumn)}
+		}
+
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				// FieldPos is not valid after a failed read
+				line := 0
+				var perr *csv.ParseError
+				if errors.As(err, &perr) {
+					line = perr.Line
+				}
+				return &ImportError{Line: line, Err: err}
+			}
+			key, err := parseCell[K](record[keyIndex])
+			var value V
+			if err == nil {
+				value, err = parseCell[V](record[valueIndex])
+			}
+			if err != nil {
+				line, _ := reader.FieldPos(0)
+				return &ImportError{Line: line, Err: err}
+			}
+			loaded.addOrSet(key, value)
+		}
+
+	default:
+		return fmt.Errorf("compactmap: unknown format %d", format.Kind)
+	}
+
+	m.Lock()
+	defer m.unlock()
+
+	m.addLoaded(loaded)
+	return nil
+}
+
+func importJSONL[K constraints.Ordered, V any](m *CompactMap[K, V], data []byte, keyColumn, valueColumn string) error {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		return err
+	}
+	rawKey, ok := object[keyColumn]
+	if !ok {
+		return fmt.Errorf("no %q field", keyColumn)
+	}
+	var key K
+	if err := json.Unmarshal(rawKey, &key); err != nil {
+		return err
+	}
+	rawValue, ok := object[valueColumn]
+	if !ok {
+		return fmt.Errorf("no %q field", valueColumn)
+	}
+	var value V
+	if err := json.Unmarshal(rawValue, &value); err != nil {
+		return err
+	}
+	m.addOrSet(key, value)
+	return nil
+}
+
+func formatCell[T any](v T) (string, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.String {
+		return rv.String(), nil
+	}
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+func parseCell[T any](cell string) (T, error) {
+	var v T
+	rv := reflect.ValueOf(&v).Elem()
+	if rv.Kind() == reflect.String {
+		rv.SetString(cell)
+		return v, nil
+	}
+	err := json.Unmarshal([]byte(cell), &v)
+	return v, err
+}
@@ -0,0 +1,85 @@
+package compactmap
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type point struct {
+	X, Y int
+}
+
+func TestExportImportJSONL(t *testing.T) {
+	cm := NewCompactMap[int, point]()
+	cm.AddOrSet(2, point{3, 4})
+	cm.AddOrSet(1, point{1, 2})
+
+	var buf bytes.Buffer
+	assert.Nil(t, cm.Export(&buf, JSONL))
+	assert.Equal(t, "{\"key\":1,\"value\":{\"X\":1,\"Y\":2}}\n{\"key\":2,\"value\":{\"X\":3,\"Y\":4}}\n", buf.String())
+
+	loaded := NewCompactMap[int, point]()
+	assert.Nil(t, loaded.Import(&buf, JSONL))
+	assert.True(t, cm.Equal(loaded, nil))
+
+	input := "{\"id\":5,\"p\":{\"X\":5}}\n\n{\"id\":6,\"p\":{\"X\":6}}"
+	loaded = NewCompactMap[int, point]()
+	assert.Nil(t, loaded.Import(strings.NewReader(input), Format{Kind: FormatJSONL, KeyColumn: "id", ValueColumn: "p"}))
+	v, _ := loaded.Get(6)
+	assert.Equal(t, point{6, 0}, v)
+
+	err := loaded.Import(strings.NewReader("{\"key\":1,\"value\":{}}\n{\"key\":\"x\",\"value\":{}}\n"), JSONL)
+	var importErr *ImportError
+	assert.True(t, errors.As(err, &importErr))
+	assert.Equal(t, 2, importErr.Line)
+	assert.False(t, loaded.Exist(1))
+
+	// missing value is an error, not a zero value
+	err = loaded.Import(strings.NewReader("{\"key\":1,\"value\":{}}\n\n{\"key\":2}\n"), JSONL)
+	assert.True(t, errors.As(err, &importErr))
+	assert.Equal(t, 3, importErr.Line)
+	assert.False(t, loaded.Exist(1))
+}
+
+func TestExportImportCSV(t *testing.T) {
+	cm := NewCompactMap[string, float64]()
+	cm.AddOrSet("b,c", 2.5)
+	cm.AddOrSet("a", 1)
+
+	var buf bytes.Buffer
+	assert.Nil(t, cm.Export(&buf, CSV))
+	assert.Equal(t, "key,value\na,1\n\"b,c\",2.5\n", buf.String())
+
+	loaded := NewCompactMap[string, float64]()
+	assert.Nil(t, loaded.Import(&buf, CSV))
+	assert.True(t, cm.Equal(loaded, nil))
+
+	format := Format{Kind: FormatCSV, KeyColumn: "name", ValueColumn: "price"}
+	input := "price,comment,name\n10,first,x\n20,second,y\n"
+	assert.Nil(t, loaded.Import(strings.NewReader(input), format))
+	v, _ := loaded.Get("y")
+	assert.Equal(t, 20.0, v)
+	assert.Equal(t, 4, loaded.Count())
+
+	err := loaded.Import(strings.NewReader("price,name\n1,z\nbad,w\n"), format)
+	var importErr *ImportError
+	assert.True(t, errors.As(err, &importErr))
+	assert.Equal(t, 3, importErr.Line)
+	assert.False(t, loaded.Exist("z"))
+
+	err = loaded.Import(strings.NewReader("a,b\n"), CSV)
+	assert.True(t, errors.As(err, &importErr))
+	assert.Equal(t, 1, importErr.Line)
+
+	// malformed quoting
+	err = loaded.Import(strings.NewReader("key,value\na,1\nb\"x,2\n"), CSV)
+	assert.True(t, errors.As(err, &importErr))
+	assert.Equal(t, 3, importErr.Line)
+	var parseErr *csv.ParseError
+	assert.True(t, errors.As(err, &parseErr))
+}
@@ -0,0 +1,111 @@
+package compactmap
+
+import (
+	"time"
+
+	"golang.org/x/exp/constraints"
+)
+
+/*
+	Functional helpers run in one pass under the read lock of the map and skip expired entries.
+	Maps they return are packed: every buffer except the last one is full.
+	Expiration times and settings of the source map are not copied.
+*/
+
+// each calls fn for not expired entries in key order until fn returns false, the map should be locked
+func (m *CompactMap[K, V]) each(fn func(key K, val V) bool) {
+	checkExpired := m.expires != nil && len(m.expires.buffers) > 0
+	now := time.Now().UnixNano()
+
+	for c := newCursor(m.buffers); c.valid(); c.next() {
+		e := c.entry()
+		if checkExpired && m.isExpired(e.Key, now) {
+			continue
+		}
+		if !fn(e.Key, e.Value) {
+			return
+		}
+	}
+}
+
+// Filter returns a new map with entries for which pred returns true
+func (m *CompactMap[K, V]) Filter(pred func(key K, val V) bool) *CompactMap[K, V] {
+	m.RLock()
+	defer m.RUnlock()
+
+	ret := NewCompactMap[K, V]()
+	m.each(func(key K, val V) bool {
+		if pred(key, val) {
+			ret.appendSorted(key, val)
+		}
+		return true
+	})
+	return ret
+}
+
+// CountIf returns the number of entries for which pred returns true
+func (m *CompactMap[K, V]) CountIf(pred func(key K, val V) bool) int {
+	m.RLock()
+	defer m.RUnlock()
+
+	n := 0
+	m.each(func(key K, val V) bool {
+		if pred(key, val) {
+			n++
+		}
+		return true
+	})
+	return n
+}
+
+// AnyMatch reports whether pred returns true for some entry, it stops at the first match
+func (m *CompactMap[K, V]) AnyMatch(pred func(key K, val V) bool) bool {
+	m.RLock()
+	defer m.RUnlock()
+
+	found := false
+	m.each(func(key K, val V) bool {
+		found = pred(key, val)
+		return !found
+	})
+	return found
+}
+
+// AllMatch reports whether pred returns true for every entry, it is true for an empty map
+func (m *CompactMap[K, V]) AllMatch(pred func(key K, val V) bool) bool {
+	m.RLock()
+	defer m.RUnlock()
+
+	all := true
+	m.each(func(key K, val V) bool {
+		all = pred(key, val)
+		return all
+	})
+	return all
+}
+
+// MapValues returns a new map with the same keys and values converted by fn
+func MapValues[K constraints.Ordered, V, V2 any](m *CompactMap[K, V], fn func(key K, val V) V2) *CompactMap[K, V2] {
+	m.RLock()
+	defer m.RUnlock()
+
+	ret := NewCompactMap[K, V2]()
+	m.each(func(key K, val V) bool {
+		ret.appendSorted(key, fn(key, val))
+		return true
+	})
+	return ret
+}
+
+// Reduce folds entries in key order into an accumulator starting with init
+func Reduce[K constraints.Ordered, V, A any](m *CompactMap[K, V], init A, fn func(acc A, key K, val V) A) A {
+	m.RLock()
+	defer m.RUnlock()
+
+	acc := init
+	m.each(func(key K, val V) bool {
+		acc = fn(acc, key, val)
+		return true
+	})
+	return acc
+}
@@ -0,0 +1,54 @@
+package compactmap
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFunctional(t *testing.T) {
+	cm := NewCompactMap[int, int]()
+	for i := 0; i < 5000; i++ {
+		cm.AddOrSet(i, i*2)
+	}
+	cm.AddOrSetWithTTL(-1, 7, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	even := func(key, val int) bool { return key%2 == 0 }
+
+	filtered := cm.Filter(even)
+	assert.Equal(t, 2500, filtered.Count())
+	assert.Equal(t, 3, filtered.StatsInfo().Buffers, "Filtered map should be packed")
+	assert.NoError(t, filtered.Validate())
+	_, ok := filtered.Get(-1)
+	assert.False(t, ok, "Expired entries should be skipped")
+	v, ok := filtered.Get(4998)
+	assert.True(t, ok)
+	assert.Equal(t, 9996, v)
+
+	assert.Equal(t, 2500, cm.CountIf(even))
+	assert.True(t, cm.AnyMatch(func(key, val int) bool { return val == 9998 }))
+	assert.False(t, cm.AnyMatch(func(key, val int) bool { return val == 7 }))
+	assert.True(t, cm.AllMatch(func(key, val int) bool { return val == key*2 }))
+	assert.False(t, cm.AllMatch(even))
+	assert.True(t, NewCompactMap[int, int]().AllMatch(even))
+
+	strs := MapValues(cm, func(key, val int) string { return strconv.Itoa(val) })
+	assert.Equal(t, 5000, strs.Count())
+	s, _ := strs.Get(21)
+	assert.Equal(t, "42", s)
+	assert.NoError(t, strs.Validate())
+
+	sum := Reduce(cm, 0, func(acc, key, val int) int { return acc + val })
+	assert.Equal(t, 4999*5000, sum)
+
+	keys := Reduce(filtered, []int{}, func(acc []int, key, val int) []int {
+		if key < 6 {
+			acc = append(acc, key)
+		}
+		return acc
+	})
+	assert.Equal(t, []int{0, 2, 4}, keys)
+}
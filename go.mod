@@ -4,26 +4,16 @@ go 1.22.3
 
 require (
 	github.com/MasterDimmy/go-ctrlc v0.0.7
-	github.com/MasterDimmy/zipologger v0.3.15
 	github.com/stretchr/testify v1.9.0
 	github.com/valyala/fasthttp v1.54.0
 	golang.org/x/exp v0.0.0-20240604190554-fc45aab8b7f8
 )
 
 require (
-	github.com/MasterDimmy/errorcatcher v0.1.1 // indirect
-	github.com/MasterDimmy/golang-lruexpire v0.2.3 // indirect
-	github.com/MasterDimmy/zilorot v0.0.5 // indirect
 	github.com/andybalholm/brotli v1.1.0 // indirect
-	github.com/btcsuite/btcd/btcec/v2 v2.3.3 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.3.0 // indirect
-	github.com/ethereum/go-ethereum v1.14.5 // indirect
-	github.com/holiman/uint256 v1.2.4 // indirect
 	github.com/klauspost/compress v1.17.8 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
-	golang.org/x/crypto v0.24.0 // indirect
-	golang.org/x/sys v0.21.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,32 @@
+package compactmap
+
+import (
+	"context"
+	"log/slog"
+)
+
+// discardHandler drops all records, the library is silent until SetLogger is called
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+var discardLogger = slog.New(discardHandler{})
+
+// SetLogger sets logger for saves and loads of the map, nil disables logging
+func (m *CompactMap[K, V]) SetLogger(logger *slog.Logger) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.logger = logger
+}
+
+// log returns logger set by SetLogger, records are dropped if it is not set
+func (m *CompactMap[K, V]) log() *slog.Logger {
+	if m.logger == nil {
+		return discardLogger
+	}
+	return m.logger
+}
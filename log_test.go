@@ -0,0 +1,38 @@
+package compactmap
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetLogger(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "log.dat")
+
+	var out bytes.Buffer
+	m := NewCompactMap[int, int]()
+	m.SetLogger(slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	m.AddOrSet(1, 1)
+
+	assert.NoError(t, m.Save(file))
+	assert.Contains(t, out.String(), "compactmap: saved")
+	assert.NoError(t, m.Save(file))
+	assert.Contains(t, out.String(), "compactmap: nothing to save")
+
+	out.Reset()
+	assert.Error(t, m.Init(file+".missing"))
+	assert.NoError(t, os.WriteFile(file, []byte("garbage"), 0644))
+	assert.Error(t, m.Init(file))
+	assert.Contains(t, out.String(), "compactmap: load failed")
+
+	// silent without logger
+	m.SetLogger(nil)
+	out.Reset()
+	m.AddOrSet(2, 2)
+	assert.NoError(t, m.Save(file))
+	assert.Empty(t, out.String())
+}
@@ -3,19 +3,24 @@ package compactmap
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/binary"
 	"encoding/gob"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/exp/constraints"
 )
 
 const maxSliceSize = 1000
 
-type Entry[K constraints.Ordered, V any] struct {
+type Entry[K any, V any] struct {
 	Key   K
 	Value V
 }
@@ -24,8 +29,29 @@ type CompactMap[K constraints.Ordered, V any] struct {
 	sync.RWMutex
 
 	buffers    []*[]Entry[K, V]
+	size       int // number of stored entries
 	changed    bool
+	mutations  atomic.Int64 // changes since the last save or load, see Mutations
+	saveMu     sync.Mutex   // one Save or Init at a time
 	loadedFile string
+	lastSave   time.Time
+
+	workers int         // snapshot encoding goroutines, see SetWorkers
+	keys    KeyProvider // snapshot encryption, see SetEncryption
+
+	expires     *CompactMap[K, int64] // expiration time in unix nanoseconds, see AddOrSetWithTTL
+	janitorStop chan struct{}
+
+	bounds *bounded[K, V] // see SetBounds
+
+	subs      []*subscriber[K, V] // see Subscribe
+	subSeq    int
+	pending   []pendingEvent[K, V] // events for OverflowBlock subscribers, sent by unlock
+	delivered chan struct{}        // closed when the last queued batch is sent
+
+	logger *slog.Logger // see SetLogger
+
+	autosave *autoSaver // see EnableAutoSave
 }
 
 func NewCompactMap[K constraints.Ordered, V any]() *CompactMap[K, V] {
@@ -38,18 +64,38 @@ func NewCompactMap[K constraints.Ordered, V any]() *CompactMap[K, V] {
 
 func (m *CompactMap[K, V]) Clear() {
 	m.Lock()
-	defer m.Unlock()
+	defer m.unlock()
+
+	m.clear()
+}
+
+func (m *CompactMap[K, V]) clear() {
+	if m.bounds != nil {
+		for _, buffer := range m.buffers {
+			for _, e := range *buffer {
+				m.bounds.Policy.Removed(e.Key)
+			}
+		}
+		m.bounds.bytes = 0
+	}
 
 	if len(m.buffers) > 0 {
 		m.buffers = m.buffers[0:0]
 	}
+	m.size = 0
+	m.expires = nil
 	m.changed = true
+	m.mutations.Add(1)
+
+	if len(m.subs) > 0 {
+		m.notify(Event[K, V]{Type: EventClear})
+	}
 }
 
 // sync.Map analog
 func (m *CompactMap[K, V]) LoadOrStore(key K, value V) (old V, loaded bool) {
 	m.Lock()
-	defer m.Unlock()
+	defer m.unlock()
 
 	old, loaded = m.get(key)
 	if loaded {
@@ -62,13 +108,16 @@ func (m *CompactMap[K, V]) LoadOrStore(key K, value V) (old V, loaded bool) {
 
 func (m *CompactMap[K, V]) LoadAndDelete(key K) (old V, loaded bool) {
 	m.Lock()
-	defer m.Unlock()
+	defer m.unlock()
 
-	old, loaded = m.get(key)
+	old, loaded, expired := m.getState(key)
 	if loaded {
 		m.delete(key)
 		return old, true
 	}
+	if expired {
+		m.delete(key)
+	}
 
 	var zero V
 	return zero, false
@@ -82,55 +131,82 @@ func (m *CompactMap[K, V]) Store(key K, value V) {
 // Add or Set
 func (m *CompactMap[K, V]) AddOrSet(key K, value V) (overwrited bool) {
 	m.Lock()
-	defer m.Unlock()
+	defer m.unlock()
 
 	return m.addOrSet(key, value)
 }
 
+// find returns buffer index and position of key in it
+func (m *CompactMap[K, V]) find(key K) (bufferIndex, index int, found bool) {
+	return find(m.buffers, key)
+}
+
+// find is findFunc with cmp.Compare written with operators,
+// so lookups of ordered keys do not call a comparator for every probe
+func find[K constraints.Ordered, V any](buffers []*[]Entry[K, V], key K) (bufferIndex, index int, found bool) {
+	n := len(buffers)
+	if n == 0 {
+		return 0, 0, false
+	}
+
+	bufferIndex = sort.Search(n, func(i int) bool {
+		buffer := *buffers[i]
+		return buffer[len(buffer)-1].Key >= key
+	})
+	if bufferIndex == n {
+		bufferIndex = n - 1
+	}
+	buffer := *buffers[bufferIndex]
+	index = sort.Search(len(buffer), func(i int) bool {
+		return buffer[i].Key >= key
+	})
+	return bufferIndex, index, index < len(buffer) && buffer[index].Key == key
+}
+
 func (m *CompactMap[K, V]) addOrSet(key K, value V) (overwrited bool) {
-	if len(m.buffers) == 0 {
-		newBuffer := &[]Entry[K, V]{Entry[K, V]{Key: key, Value: value}}
-		m.buffers = append(m.buffers, newBuffer)
-		m.changed = true
-		overwrited = false
-		return
+	if m.bounds != nil {
+		m.makeRoom(key, value)
 	}
 
-	bufferIndex := len(m.buffers) - 1
+	old, overwrited := m.store(key, value)
 
-	buffer := m.buffers[bufferIndex]
-	if buffer != nil && len(*buffer) < maxSliceSize {
-		index := sort.Search(len(*buffer), func(i int) bool {
-			return (*buffer)[i].Key >= key
-		})
+	if len(m.subs) > 0 {
+		m.notify(Event[K, V]{Type: EventSet, Key: key, Old: old, HadOld: overwrited, New: value})
+	}
 
-		if index < len(*buffer) && (*buffer)[index].Key == key {
-			(*buffer)[index].Value = value
-			overwrited = true
+	if m.bounds != nil {
+		m.bounds.bytes += m.bounds.Size(key, value)
+		if overwrited {
+			m.bounds.bytes -= m.bounds.Size(key, old)
+			m.bounds.Policy.Accessed(key)
 		} else {
-			*buffer = append(*buffer, Entry[K, V]{})
-			copy((*buffer)[index+1:], (*buffer)[index:])
-			(*buffer)[index] = Entry[K, V]{Key: key, Value: value}
-			overwrited = false
+			m.bounds.Policy.Added(key)
 		}
-		m.changed = true
-		return
+		m.evict()
 	}
+	return overwrited
+}
 
-	if buffer == nil {
-		m.buffers[bufferIndex] = &[]Entry[K, V]{Entry[K, V]{Key: key, Value: value}}
-		m.changed = true
-		overwrited = false
-		return
+// store puts value into buffers, returns previous value if overwritten
+func (m *CompactMap[K, V]) store(key K, value V) (old V, overwrited bool) {
+	m.changed = true
+	m.mutations.Add(1)
+
+	if m.expires != nil {
+		m.expires.delete(key)
 	}
 
-	// If no appropriate buffer found, create a new one
-	newBuffer := &[]Entry[K, V]{Entry[K, V]{Key: key, Value: value}}
-	m.buffers = append(m.buffers, newBuffer)
-	overwrited = false
+	bufferIndex, index, found := m.find(key)
+	if found {
+		entry := &(*m.buffers[bufferIndex])[index]
+		old = entry.Value
+		entry.Value = value
+		return old, true
+	}
 
-	m.changed = true
-	return
+	insertAt(&m.buffers, bufferIndex, index, Entry[K, V]{Key: key, Value: value})
+	m.size++
+	return old, false
 }
 
 // alias map-compatible
@@ -140,66 +216,66 @@ func (m *CompactMap[K, V]) Load(key K) (V, bool) {
 
 func (m *CompactMap[K, V]) Get(key K) (V, bool) {
 	m.RLock()
-	defer m.RUnlock()
+	value, found, expired := m.getState(key)
+	m.RUnlock()
 
-	return m.get(key)
+	if expired {
+		m.deleteExpired(key)
+	}
+	return value, found
 }
 
 func (m *CompactMap[K, V]) get(key K) (V, bool) {
-	for bufferIndex := range len(m.buffers) {
-		buffer := m.buffers[bufferIndex]
-		if buffer == nil {
-			continue
-		}
-		if len(*buffer) == 0 {
-			continue
-		}
-		index := sort.Search(len(*buffer), func(i int) bool {
-			return (*buffer)[i].Key >= key
-		})
+	value, found, _ := m.getState(key)
+	return value, found
+}
 
-		if index < len(*buffer) && (*buffer)[index].Key == key {
-			return (*buffer)[index].Value, true
-		}
+// getState also reports whether key is stored but expired
+func (m *CompactMap[K, V]) getState(key K) (value V, found, expired bool) {
+	bufferIndex, index, found := m.find(key)
+	if !found {
+		return value, false, false
 	}
-
-	var zero V
-	return zero, false
+	if m.expires != nil && m.isExpired(key, time.Now().UnixNano()) {
+		return value, false, true
+	}
+	if m.bounds != nil {
+		m.bounds.Policy.Accessed(key)
+	}
+	return (*m.buffers[bufferIndex])[index].Value, true, false
 }
 
 func (m *CompactMap[K, V]) Delete(key K) {
 	m.Lock()
-	defer m.Unlock()
+	defer m.unlock()
 
 	m.delete(key)
 }
 
 func (m *CompactMap[K, V]) delete(key K) {
-	for bufferIndex := range len(m.buffers) {
-		buffer := m.buffers[bufferIndex]
-		if buffer == nil {
-			continue
-		}
-		if len(*buffer) == 0 {
-			continue
-		}
+	bufferIndex, index, found := m.find(key)
+	if !found {
+		return
+	}
 
-		index := sort.Search(len(*buffer), func(i int) bool {
-			return (*buffer)[i].Key >= key
-		})
+	old := (*m.buffers[bufferIndex])[index].Value
+
+	if m.bounds != nil {
+		m.bounds.bytes -= m.bounds.Size(key, old)
+		m.bounds.Policy.Removed(key)
+	}
 
-		if index < len(*buffer) && (*buffer)[index].Key == key {
-			//remove element in inner buffer
-			*buffer = append((*buffer)[:index], (*buffer)[index+1:]...)
-			m.changed = true
+	removeAt(&m.buffers, bufferIndex, index)
+	m.size--
+	m.changed = true
+	m.mutations.Add(1)
 
-			if len(*buffer) == 0 {
-				//remove whole slice
-				m.buffers = append((m.buffers)[:bufferIndex], (m.buffers)[bufferIndex+1:]...)
-			}
+	if m.expires != nil {
+		m.expires.delete(key)
+	}
 
-			return
-		}
+	if len(m.subs) > 0 {
+		m.notify(Event[K, V]{Type: EventDelete, Key: key, Old: old, HadOld: true})
 	}
 }
 
@@ -213,10 +289,16 @@ func (m *CompactMap[K, V]) Iterate(fn func(key K, val V) bool) {
 	m.RLock()
 	defer m.RUnlock()
 
+	checkExpired := m.expires != nil && len(m.expires.buffers) > 0
+	now := time.Now().UnixNano()
+
 	for _, buffer := range m.buffers {
 		if buffer != nil {
 			buffer_ := *buffer
 			for _, k := range buffer_ {
+				if checkExpired && m.isExpired(k.Key, now) {
+					continue
+				}
 				if !fn(k.Key, k.Value) {
 					return
 				}
@@ -227,161 +309,243 @@ func (m *CompactMap[K, V]) Iterate(fn func(key K, val V) bool) {
 
 func (m *CompactMap[K, V]) Exist(key K) bool {
 	m.RLock()
-	defer m.RUnlock()
-
-	for bufferIndex := range len(m.buffers) {
-		buffer := m.buffers[bufferIndex]
-		if buffer == nil {
-			continue
-		}
-		index := sort.Search(len(*buffer), func(i int) bool {
-			return (*buffer)[i].Key >= key
-		})
+	_, found, expired := m.getState(key)
+	m.RUnlock()
 
-		if index < len(*buffer) && (*buffer)[index].Key == key {
-			return true
-		}
+	if expired {
+		m.deleteExpired(key)
 	}
-	return false
+	return found
 }
 
+// Count returns number of stored entries.
+// Expired entries are counted until they are removed on access or by the janitor, see DeleteExpired.
 func (m *CompactMap[K, V]) Count() int {
 	m.RLock()
 	defer m.RUnlock()
 
-	count := 0
-	for _, buffer := range m.buffers {
-		if buffer != nil {
-			count += len(*buffer)
-		}
-	}
-	return count
+	return m.count()
 }
 
+// count returns number of stored entries
+func (m *CompactMap[K, V]) count() int {
+	return m.size
+}
+
+// Stats returns buffer and entry counts without walking entries, see StatsInfo for details
 func (m *CompactMap[K, V]) Stats() string {
 	m.RLock()
 	defer m.RUnlock()
 
-	count := 0
-	for _, buffer := range m.buffers {
-		if buffer != nil {
-			count += len(*buffer)
-		}
-	}
-
-	str := fmt.Sprintf("%d buffers, total len: %d", len(m.buffers), count)
+	str := fmt.Sprintf("%d buffers, total len: %d", len(m.buffers), m.size)
 
 	return str
 }
 
+// Save stores the map into filename, see SaveContext
 func (m *CompactMap[K, V]) Save(filename string) error {
-	m.RLock()
-	defer m.RUnlock()
+	return m.SaveContext(context.Background(), filename, nil)
+}
+
+// SaveContext stores the map into filename.
+// The snapshot is written to a temporary file first and renamed when complete,
+// so a cancelled or failed save leaves the previous file untouched.
+// progress, if not nil, is called periodically with the number of entries and bytes written.
+// Saves and loads run one at a time, readers are not blocked while the file is written.
+// Only saving into the own file of the map marks its changes saved, see Mutations.
+func (m *CompactMap[K, V]) SaveContext(ctx context.Context, filename string, progress ProgressFunc) error {
+	m.saveMu.Lock()
+	defer m.saveMu.Unlock()
 
+	m.RLock()
+	log := m.log()
 	if m.loadedFile == filename && !m.changed {
-		fmt.Println("nothing to save")
+		m.RUnlock()
+		log.Debug("compactmap: nothing to save", "file", filename)
 		return nil
 	}
 
-	file, err := os.Create(filename)
-	if err != nil {
-		return err
+	var expiry func(key K) int64
+	if m.expires != nil && len(m.expires.buffers) > 0 {
+		expiry = m.expiration
 	}
-	defer file.Close()
 
-	const bufferSize = 50 * 1024 * 1024 // 50MB
-	writer := bufio.NewWriterSize(file, bufferSize)
+	saved := m.mutations.Load()
+	entries := m.size
+	start := time.Now()
+	err := saveFile(filename, func(file *os.File) error {
+		return writeSnapshot(ctx, file, m.chunks(), expiry, m.snapshotOptions(progress))
+	})
+	m.RUnlock()
 
-	writeToFile := func(data []byte) error {
-		if _, err := writer.Write(data); err != nil {
-			return err
-		}
-		return nil
+	if err != nil {
+		log.Error("compactmap: save failed", "file", filename, "err", err)
+		return err
 	}
+	log.Debug("compactmap: saved", "file", filename, "entries", entries, "duration", time.Since(start))
 
-	// Write number of entries
-	totalEntries := 0 //Count()
-	for _, buffer := range m.buffers {
-		if buffer != nil {
-			totalEntries += len(*buffer)
-		}
+	// changes made after the read lock was released stay unsaved
+	m.Lock()
+	if m.isOwnFile(filename) {
+		m.mutations.Add(-saved)
+		m.changed = m.mutations.Load() != 0
+		m.loadedFile = filename
 	}
+	m.lastSave = time.Now()
+	m.Unlock()
+	return nil
+}
 
-	totalEntriesBuf := make([]byte, 8)
-	binary.LittleEndian.PutUint64(totalEntriesBuf, uint64(totalEntries))
-	if err := writeToFile(totalEntriesBuf); err != nil {
-		return err
+// isOwnFile reports whether saving into filename makes the map clean:
+// the auto save file if it is enabled, otherwise the file loaded or saved before, or any file for a new map.
+// Copies such as backups leave changes pending for the own file.
+func (m *CompactMap[K, V]) isOwnFile(filename string) bool {
+	if m.autosave != nil {
+		return filename == m.autosave.filename
 	}
+	return m.loadedFile == "" || m.loadedFile == filename
+}
 
-	var buf4 [4]byte
+// Init loads entries stored by Save from filename, see InitContext
+func (m *CompactMap[K, V]) Init(filename string) error {
+	return m.InitContext(context.Background(), filename, nil)
+}
 
-	for _, buffer_ := range m.buffers {
-		if buffer_ == nil {
-			continue
-		}
+// InitContext loads entries stored by Save from filename.
+// Entries are added to the map only when the whole file has been read,
+// so a cancelled or failed load leaves the map unchanged.
+// progress, if not nil, is called periodically with the number of entries and bytes read.
+func (m *CompactMap[K, V]) InitContext(ctx context.Context, filename string, progress ProgressFunc) error {
+	m.saveMu.Lock()
+	defer m.saveMu.Unlock()
 
-		buffer := *buffer_
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
 
-		// Write keys and values
-		for _, entry := range buffer {
-			keyData, err := Serialize(entry.Key)
-			if err != nil {
-				return err
-			}
-			valueData, err := Serialize(entry.Value)
-			if err != nil {
-				return err
-			}
+	st, err := file.Stat()
+	if err != nil {
+		return err
+	}
 
-			// Write key size and key
-			binary.LittleEndian.PutUint32(buf4[:], uint32(len(keyData)))
-			if err := writeToFile(buf4[:]); err != nil {
-				return err
-			}
-			if err := writeToFile(keyData); err != nil {
-				return err
-			}
+	m.RLock()
+	opts := m.snapshotOptions(progress)
+	log := m.log()
+	m.RUnlock()
 
-			// Write value size and value
-			binary.LittleEndian.PutUint32(buf4[:], uint32(len(valueData)))
-			if err := writeToFile(buf4[:]); err != nil {
-				return err
-			}
-			if err := writeToFile(valueData); err != nil {
-				return err
-			}
-		}
+	loaded, err := readMap[K, V](ctx, file, st.Size(), opts)
+	if err != nil {
+		log.Error("compactmap: load failed", "file", filename, "err", err)
+		return err
 	}
 
-	err = writer.Flush()
+	m.Lock()
+	defer m.unlock()
 
+	m.addLoaded(loaded)
 	m.changed = false
+	m.mutations.Store(0)
 	m.loadedFile = filename
-	return err
+	m.log().Debug("compactmap: loaded", "file", filename, "entries", loaded.size)
+	return nil
 }
 
-func (m *CompactMap[K, V]) Init(filename string) error {
-	file, err := os.Open(filename)
+// readMap reads snapshot or legacy file into a new map
+func readMap[K constraints.Ordered, V any](ctx context.Context, file interface {
+	io.Reader
+	io.ReaderAt
+}, size int64, opts snapshotOptions) (*CompactMap[K, V], error) {
+	var err error
+	loaded := NewCompactMap[K, V]()
+	if isSnapshot(file) {
+		now := time.Now().UnixNano()
+		err = readSnapshot(ctx, file, size, opts, func(entries []Entry[K, V], expires []int64) {
+			for i, entry := range entries {
+				if expiredAt(expires, i, now) {
+					continue
+				}
+				loaded.addOrSet(entry.Key, entry.Value)
+				if expires != nil && expires[i] != 0 {
+					loaded.setExpiration(entry.Key, expires[i])
+				}
+			}
+		})
+	} else if opts.keys != nil {
+		err = ErrNotEncrypted
+	} else {
+		err = loaded.readLegacy(ctx, file, size, opts.progress)
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer file.Close()
+	return loaded, nil
+}
+
+// addLoaded takes buffers of loaded if m is empty or adds its entries, called under the write lock
+func (m *CompactMap[K, V]) addLoaded(loaded *CompactMap[K, V]) {
+	if len(m.buffers) == 0 {
+		m.buffers = loaded.buffers
+		m.size = loaded.size
+		m.expires = loaded.expires
+		m.mutations.Add(int64(loaded.size))
+		if len(m.subs) > 0 {
+			for _, buffer := range m.buffers {
+				for _, e := range *buffer {
+					m.notify(Event[K, V]{Type: EventSet, Key: e.Key, New: e.Value})
+				}
+			}
+		}
+		if m.bounds != nil {
+			m.bounds.bytes = 0
+			m.trackAll()
+		}
+		return
+	}
+
+	for _, buffer := range loaded.buffers {
+		for _, entry := range *buffer {
+			m.addOrSet(entry.Key, entry.Value)
+			if loaded.expires != nil {
+				if exp := loaded.expiration(entry.Key); exp != 0 {
+					m.setExpiration(entry.Key, exp)
+				}
+			}
+		}
+	}
+}
 
-	reader := bufio.NewReaderSize(file, 50*1024*1024) // 50MB buffer
+// readLegacy reads snapshots written before the chunked format, size is the size of the file
+func (m *CompactMap[K, V]) readLegacy(ctx context.Context, file io.Reader, size int64, progress ProgressFunc) error {
+	counter := &countingReader{r: file}
+	reader := bufio.NewReaderSize(counter, 50*1024*1024) // 50MB buffer
 
 	var numEntries int64
 	if err := binary.Read(reader, binary.LittleEndian, &numEntries); err != nil {
 		return err
 	}
 
+	// next reads a field prefixed with its int32 size, the size is checked against the rest of the file
+	next := func() ([]byte, error) {
+		var fieldSize int32
+		if err := binary.Read(reader, binary.LittleEndian, &fieldSize); err != nil {
+			return nil, err
+		}
+		if fieldSize < 0 || int64(fieldSize) > size-(counter.n-int64(reader.Buffered())) {
+			return nil, ErrBadSnapshot
+		}
+		data := make([]byte, fieldSize)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+
 	// Read keys and values
 	for i := int64(0); i < numEntries; i++ {
-		var keySize int32
-		if err := binary.Read(reader, binary.LittleEndian, &keySize); err != nil {
-			return err
-		}
-		keyData := make([]byte, keySize)
-		if _, err := reader.Read(keyData); err != nil {
+		keyData, err := next()
+		if err != nil {
 			return err
 		}
 		key, err := Deserialize[K](keyData)
@@ -389,12 +553,8 @@ func (m *CompactMap[K, V]) Init(filename string) error {
 			return err
 		}
 
-		var valueSize int32
-		if err := binary.Read(reader, binary.LittleEndian, &valueSize); err != nil {
-			return err
-		}
-		valueData := make([]byte, valueSize)
-		if _, err := reader.Read(valueData); err != nil {
+		valueData, err := next()
+		if err != nil {
 			return err
 		}
 		value, err := Deserialize[V](valueData)
@@ -403,10 +563,20 @@ func (m *CompactMap[K, V]) Init(filename string) error {
 		}
 
 		m.addOrSet(key, value)
+
+		if (i+1)%progressEvery == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if progress != nil {
+				progress(i+1, counter.n-int64(reader.Buffered()))
+			}
+		}
 	}
 
-	m.changed = false
-	m.loadedFile = filename
+	if progress != nil {
+		progress(numEntries, counter.n-int64(reader.Buffered()))
+	}
 	return nil
 }
 
@@ -0,0 +1,330 @@
+package compactmap
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+	CompactMapFunc is CompactMap for keys without natural order:
+	arrays like [16]byte UUIDs, structs, composite tuples.
+	Keys are ordered by the cmp function given to NewCompactMapFunc,
+	buffers are kept by the same code as of CompactMap (see buffers.go) and the snapshot format is the same.
+*/
+
+type CompactMapFunc[K comparable, V any] struct {
+	sync.RWMutex
+
+	cmp func(a, b K) int // negative if a < b, zero if a == b, positive if a > b
+
+	buffers    []*[]Entry[K, V]
+	size       int // number of stored entries
+	changed    bool
+	saveMu     sync.Mutex // one Save or Init at a time
+	loadedFile string
+	lastSave   time.Time
+
+	workers int         // snapshot encoding goroutines, see SetWorkers
+	keys    KeyProvider // snapshot encryption, see SetEncryption
+}
+
+// NewCompactMapFunc creates map with keys ordered by cmp,
+// cmp(a, b) should return negative if a < b, zero if a == b, positive if a > b
+func NewCompactMapFunc[K comparable, V any](cmp func(a, b K) int) *CompactMapFunc[K, V] {
+	return &CompactMapFunc[K, V]{
+		cmp:     cmp,
+		buffers: make([]*[]Entry[K, V], 0, 100),
+	}
+}
+
+func (m *CompactMapFunc[K, V]) Clear() {
+	m.Lock()
+	defer m.Unlock()
+
+	if len(m.buffers) > 0 {
+		m.buffers = m.buffers[0:0]
+	}
+	m.size = 0
+	m.changed = true
+}
+
+// sync.Map analog
+func (m *CompactMapFunc[K, V]) LoadOrStore(key K, value V) (old V, loaded bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	old, loaded = m.get(key)
+	if loaded {
+		return old, true
+	}
+
+	m.addOrSet(key, value)
+	return value, false
+}
+
+func (m *CompactMapFunc[K, V]) LoadAndDelete(key K) (old V, loaded bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	old, loaded = m.get(key)
+	if loaded {
+		m.delete(key)
+		return old, true
+	}
+
+	var zero V
+	return zero, false
+}
+
+// sync.map - compatible
+func (m *CompactMapFunc[K, V]) Store(key K, value V) {
+	m.AddOrSet(key, value)
+}
+
+// Add or Set
+func (m *CompactMapFunc[K, V]) AddOrSet(key K, value V) (overwrited bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.addOrSet(key, value)
+}
+
+// find returns buffer index and position of key in it
+func (m *CompactMapFunc[K, V]) find(key K) (bufferIndex, index int, found bool) {
+	return findFunc(m.buffers, key, m.cmp)
+}
+
+func (m *CompactMapFunc[K, V]) addOrSet(key K, value V) (overwrited bool) {
+	m.changed = true
+
+	bufferIndex, index, found := m.find(key)
+	if found {
+		(*m.buffers[bufferIndex])[index].Value = value
+		return true
+	}
+
+	insertAt(&m.buffers, bufferIndex, index, Entry[K, V]{Key: key, Value: value})
+	m.size++
+	return false
+}
+
+// alias map-compatible
+func (m *CompactMapFunc[K, V]) Load(key K) (V, bool) {
+	return m.Get(key)
+}
+
+func (m *CompactMapFunc[K, V]) Get(key K) (V, bool) {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.get(key)
+}
+
+func (m *CompactMapFunc[K, V]) get(key K) (V, bool) {
+	bufferIndex, index, found := m.find(key)
+	if !found {
+		var zero V
+		return zero, false
+	}
+	return (*m.buffers[bufferIndex])[index].Value, true
+}
+
+func (m *CompactMapFunc[K, V]) Delete(key K) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.delete(key)
+}
+
+func (m *CompactMapFunc[K, V]) delete(key K) {
+	bufferIndex, index, found := m.find(key)
+	if !found {
+		return
+	}
+
+	removeAt(&m.buffers, bufferIndex, index)
+	m.size--
+	m.changed = true
+}
+
+// sync.Map alias
+func (m *CompactMapFunc[K, V]) Range(fn func(key K, val V) bool) {
+	m.Iterate(fn)
+}
+
+// dont modify database in iterate!
+func (m *CompactMapFunc[K, V]) Iterate(fn func(key K, val V) bool) {
+	m.RLock()
+	defer m.RUnlock()
+
+	for _, buffer := range m.buffers {
+		for _, k := range *buffer {
+			if !fn(k.Key, k.Value) {
+				return
+			}
+		}
+	}
+}
+
+func (m *CompactMapFunc[K, V]) Exist(key K) bool {
+	m.RLock()
+	defer m.RUnlock()
+
+	_, _, found := m.find(key)
+	return found
+}
+
+func (m *CompactMapFunc[K, V]) Count() int {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.size
+}
+
+// Stats returns buffer and entry counts without walking entries, see StatsInfo for details
+func (m *CompactMapFunc[K, V]) Stats() string {
+	m.RLock()
+	defer m.RUnlock()
+
+	return fmt.Sprintf("%d buffers, total len: %d", len(m.buffers), m.size)
+}
+
+// StatsInfo collects statistics of buffers and memory usage
+func (m *CompactMapFunc[K, V]) StatsInfo() StatsInfo {
+	m.RLock()
+	defer m.RUnlock()
+
+	info := statsOf(m.buffers)
+	info.Dirty = m.changed
+	info.LastSave = m.lastSave
+	info.LoadedFile = m.loadedFile
+	return info
+}
+
+// SetWorkers sets how many goroutines encode and decode snapshot chunks, see CompactMap.SetWorkers
+func (m *CompactMapFunc[K, V]) SetWorkers(n int) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.workers = n
+}
+
+// SetEncryption enables snapshot encryption, see CompactMap.SetEncryption
+func (m *CompactMapFunc[K, V]) SetEncryption(keys KeyProvider) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.keys = keys
+	m.changed = true
+}
+
+func (m *CompactMapFunc[K, V]) snapshotOptions(progress ProgressFunc) snapshotOptions {
+	return snapshotOptions{
+		workers:  workersOrDefault(m.workers),
+		progress: progress,
+		keys:     m.keys,
+	}
+}
+
+// Save stores the map into filename, see SaveContext
+func (m *CompactMapFunc[K, V]) Save(filename string) error {
+	return m.SaveContext(context.Background(), filename, nil)
+}
+
+// SaveContext stores the map into filename, see CompactMap.SaveContext
+func (m *CompactMapFunc[K, V]) SaveContext(ctx context.Context, filename string, progress ProgressFunc) error {
+	m.saveMu.Lock()
+	defer m.saveMu.Unlock()
+
+	// changes made while the file is written mark the map changed again
+	m.Lock()
+	if m.loadedFile == filename && !m.changed {
+		m.Unlock()
+		return nil
+	}
+	m.changed = false
+	m.Unlock()
+
+	m.RLock()
+	err := saveFile(filename, func(file *os.File) error {
+		return writeSnapshot[K, V](ctx, file, chunksOf(m.buffers), nil, m.snapshotOptions(progress))
+	})
+	m.RUnlock()
+
+	m.Lock()
+	defer m.Unlock()
+
+	if err != nil {
+		m.changed = true
+		return err
+	}
+	m.loadedFile = filename
+	m.lastSave = time.Now()
+	return nil
+}
+
+// Init loads entries stored by Save from filename, see InitContext
+func (m *CompactMapFunc[K, V]) Init(filename string) error {
+	return m.InitContext(context.Background(), filename, nil)
+}
+
+// InitContext loads entries stored by Save from filename, see CompactMap.InitContext.
+// Entries already expired are skipped, expiration times of the others are not kept.
+func (m *CompactMapFunc[K, V]) InitContext(ctx context.Context, filename string, progress ProgressFunc) error {
+	m.saveMu.Lock()
+	defer m.saveMu.Unlock()
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	st, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	m.RLock()
+	opts := m.snapshotOptions(progress)
+	m.RUnlock()
+
+	if !isSnapshot(file) {
+		return ErrBadSnapshot
+	}
+
+	loaded := NewCompactMapFunc[K, V](m.cmp)
+	now := time.Now().UnixNano()
+	err = readSnapshot(ctx, file, st.Size(), opts, func(entries []Entry[K, V], expires []int64) {
+		for i, entry := range entries {
+			if expiredAt(expires, i, now) {
+				continue
+			}
+			loaded.addOrSet(entry.Key, entry.Value)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	if len(m.buffers) == 0 {
+		m.buffers = loaded.buffers
+		m.size = loaded.size
+	} else {
+		for _, buffer := range loaded.buffers {
+			for _, entry := range *buffer {
+				m.addOrSet(entry.Key, entry.Value)
+			}
+		}
+	}
+
+	m.changed = false
+	m.loadedFile = filename
+	return nil
+}
@@ -0,0 +1,148 @@
+package compactmap
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"math/rand"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type uuid [16]byte
+
+func uuidOf(i int) uuid {
+	var u uuid
+	binary.BigEndian.PutUint64(u[8:], uint64(i))
+	return u
+}
+
+func compareUUID(a, b uuid) int {
+	return bytes.Compare(a[:], b[:])
+}
+
+func TestCompactMapFunc(t *testing.T) {
+	cm := NewCompactMapFunc[uuid, int](compareUUID)
+
+	const n = 5000
+	for _, i := range rand.Perm(n) {
+		assert.False(t, cm.AddOrSet(uuidOf(i), i))
+	}
+	assert.True(t, cm.AddOrSet(uuidOf(10), -10))
+	assert.Equal(t, n, cm.Count())
+
+	v, ok := cm.Get(uuidOf(10))
+	assert.True(t, ok)
+	assert.Equal(t, -10, v)
+	assert.False(t, cm.Exist(uuidOf(n)))
+
+	prev := -1
+	cm.Iterate(func(key uuid, val int) bool {
+		i := int(binary.BigEndian.Uint64(key[8:]))
+		assert.Equal(t, prev+1, i)
+		prev = i
+		return true
+	})
+	assert.Equal(t, n-1, prev)
+
+	for i := 0; i < n; i += 2 {
+		cm.Delete(uuidOf(i))
+	}
+	assert.Equal(t, n/2, cm.Count())
+	assert.False(t, cm.Exist(uuidOf(0)))
+	assert.True(t, cm.Exist(uuidOf(1)))
+
+	old, loaded := cm.LoadOrStore(uuidOf(1), 100)
+	assert.True(t, loaded)
+	assert.Equal(t, 1, old)
+	old, loaded = cm.LoadAndDelete(uuidOf(1))
+	assert.True(t, loaded)
+	assert.Equal(t, 1, old)
+	assert.Equal(t, n/2-1, cm.Count())
+}
+
+type pair struct {
+	A string
+	B int
+}
+
+func comparePair(a, b pair) int {
+	if a.A != b.A {
+		if a.A < b.A {
+			return -1
+		}
+		return 1
+	}
+	return a.B - b.B
+}
+
+func TestCompactMapFuncSaveInit(t *testing.T) {
+	cm := NewCompactMapFunc[pair, string](comparePair)
+	for i := 0; i < 3000; i++ {
+		cm.AddOrSet(pair{A: string(rune('a' + i%3)), B: i}, "v")
+	}
+
+	file := filepath.Join(t.TempDir(), "func.dat")
+	assert.Nil(t, cm.Save(file))
+
+	loaded := NewCompactMapFunc[pair, string](comparePair)
+	assert.Nil(t, loaded.Init(file))
+	assert.Equal(t, cm.Count(), loaded.Count())
+
+	var first pair
+	loaded.Iterate(func(key pair, val string) bool {
+		first = key
+		return false
+	})
+	assert.Equal(t, pair{A: "a", B: 0}, first)
+
+	v, ok := loaded.Get(pair{A: "c", B: 2})
+	assert.True(t, ok)
+	assert.Equal(t, "v", v)
+}
+
+func TestCompactMapFuncInitSkipsExpired(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ttl.dat")
+	saveExpiring(t, file, func(i int) int { return i }, "v")
+
+	loaded := NewCompactMapFunc[int, string](cmp.Compare[int])
+	assert.Nil(t, loaded.Init(file))
+	assert.Equal(t, 5, loaded.Count())
+	assert.True(t, loaded.Exist(2))
+	assert.False(t, loaded.Exist(3), "Expired key should not be loaded")
+}
+
+// testConcurrentSave runs 4 writers saving into file now and then, the last save should hold all 800 entries
+func testConcurrentSave(t *testing.T, set func(i int), save func(file string) error, count func(file string) int) {
+	file := filepath.Join(t.TempDir(), "concurrent.dat")
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				set(g*1000 + i)
+				if i%10 == 0 {
+					assert.NoError(t, save(file))
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	assert.NoError(t, save(file))
+	assert.Equal(t, 800, count(file))
+}
+
+func TestCompactMapFuncConcurrentSave(t *testing.T) {
+	cm := NewCompactMapFunc[int, int](cmp.Compare[int])
+	testConcurrentSave(t, func(i int) { cm.AddOrSet(i, i) }, cm.Save, func(file string) int {
+		loaded := NewCompactMapFunc[int, int](cmp.Compare[int])
+		assert.NoError(t, loaded.Init(file))
+		return loaded.Count()
+	})
+}
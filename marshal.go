@@ -0,0 +1,176 @@
+package compactmap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"time"
+)
+
+// MarshalBinary encodes the map in the snapshot format of Save, including expiration times and encryption
+func (m *CompactMap[K, V]) MarshalBinary() ([]byte, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	var expiry func(key K) int64
+	if m.expires != nil && len(m.expires.buffers) > 0 {
+		expiry = m.expiration
+	}
+
+	var buf bytes.Buffer
+	err := writeSnapshot(context.Background(), &buf, m.chunks(), expiry, m.snapshotOptions(nil))
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary replaces entries of the map with entries encoded by MarshalBinary
+func (m *CompactMap[K, V]) UnmarshalBinary(data []byte) error {
+	m.RLock()
+	opts := m.snapshotOptions(nil)
+	m.RUnlock()
+
+	loaded, err := readMap[K, V](context.Background(), bytes.NewReader(data), int64(len(data)), opts)
+	if err != nil {
+		return err
+	}
+
+	m.Lock()
+	defer m.unlock()
+
+	m.clear()
+	m.addLoaded(loaded)
+	return nil
+}
+
+// GobEncode lets the map be a field of gob encoded structs, see MarshalBinary
+func (m *CompactMap[K, V]) GobEncode() ([]byte, error) {
+	return m.MarshalBinary()
+}
+
+// GobDecode is the pair of GobEncode, see UnmarshalBinary
+func (m *CompactMap[K, V]) GobDecode(data []byte) error {
+	return m.UnmarshalBinary(data)
+}
+
+// MarshalJSON encodes the map as an object for string keys
+// and as an array of [key, value] pairs for other keys.
+// Expired entries are skipped, expiration times are not encoded.
+func (m *CompactMap[K, V]) MarshalJSON() ([]byte, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	object := isStringKind[K]()
+	checkExpired := m.expires != nil && len(m.expires.buffers) > 0
+	now := time.Now().UnixNano()
+
+	var buf bytes.Buffer
+	if object {
+		buf.WriteByte('{')
+	} else {
+		buf.WriteByte('[')
+	}
+
+	first := true
+	for c := newCursor(m.buffers); c.valid(); c.next() {
+		e := c.entry()
+		if checkExpired && m.isExpired(e.Key, now) {
+			continue
+		}
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+
+		var key []byte
+		var err error
+		if object {
+			key, err = json.Marshal(reflect.ValueOf(e.Key).String())
+		} else {
+			key, err = json.Marshal(e.Key)
+		}
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(e.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		if object {
+			buf.Write(key)
+			buf.WriteByte(':')
+			buf.Write(value)
+		} else {
+			buf.WriteByte('[')
+			buf.Write(key)
+			buf.WriteByte(',')
+			buf.Write(value)
+			buf.WriteByte(']')
+		}
+	}
+
+	if object {
+		buf.WriteByte('}')
+	} else {
+		buf.WriteByte(']')
+	}
+	return buf.Bytes(), nil
+}
+
+var errBadPair = errors.New("compactmap: json pair should be [key, value]")
+
+// UnmarshalJSON replaces entries of the map with entries encoded by MarshalJSON
+func (m *CompactMap[K, V]) UnmarshalJSON(data []byte) error {
+	loaded := NewCompactMap[K, V]()
+
+	if isStringKind[K]() {
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(data, &object); err != nil {
+			return err
+		}
+		for k, raw := range object {
+			var key K
+			reflect.ValueOf(&key).Elem().SetString(k)
+			var value V
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return err
+			}
+			loaded.addOrSet(key, value)
+		}
+	} else {
+		var pairs [][]json.RawMessage
+		if err := json.Unmarshal(data, &pairs); err != nil {
+			return err
+		}
+		for _, pair := range pairs {
+			if len(pair) != 2 {
+				return errBadPair
+			}
+			var key K
+			if err := json.Unmarshal(pair[0], &key); err != nil {
+				return err
+			}
+			var value V
+			if err := json.Unmarshal(pair[1], &value); err != nil {
+				return err
+			}
+			loaded.addOrSet(key, value)
+		}
+	}
+
+	m.Lock()
+	defer m.unlock()
+
+	m.clear()
+	m.addLoaded(loaded)
+	return nil
+}
+
+func isStringKind[K any]() bool {
+	var key K
+	return reflect.TypeOf(key).Kind() == reflect.String
+}
@@ -0,0 +1,81 @@
+package compactmap
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type holder struct {
+	Name  string
+	Items *CompactMap[int, string]
+}
+
+func TestMarshalBinary(t *testing.T) {
+	cm := NewCompactMap[int, string]()
+	for i := 0; i < 2000; i++ {
+		cm.AddOrSet(i, "v")
+	}
+	cm.AddOrSetWithTTL(-1, "ttl", time.Hour)
+
+	data, err := cm.MarshalBinary()
+	assert.Nil(t, err)
+
+	loaded := NewCompactMap[int, string]()
+	loaded.AddOrSet(5000, "old")
+	assert.Nil(t, loaded.UnmarshalBinary(data))
+	assert.Equal(t, 2001, loaded.Count())
+	assert.False(t, loaded.Exist(5000))
+	_, ok := loaded.TTL(-1)
+	assert.True(t, ok)
+
+	assert.NotNil(t, loaded.UnmarshalBinary([]byte("garbage")))
+}
+
+func TestGobField(t *testing.T) {
+	h := holder{Name: "h", Items: NewCompactMap[int, string]()}
+	h.Items.AddOrSet(1, "one")
+	h.Items.AddOrSet(2, "two")
+
+	var buf bytes.Buffer
+	assert.Nil(t, gob.NewEncoder(&buf).Encode(h))
+
+	var decoded holder
+	assert.Nil(t, gob.NewDecoder(&buf).Decode(&decoded))
+	assert.Equal(t, "h", decoded.Name)
+	assert.Equal(t, 2, decoded.Items.Count())
+	v, _ := decoded.Items.Get(2)
+	assert.Equal(t, "two", v)
+}
+
+func TestMarshalJSON(t *testing.T) {
+	type name string
+	cm := NewCompactMap[name, int]()
+	cm.AddOrSet("b", 2)
+	cm.AddOrSet("a", 1)
+
+	data, err := json.Marshal(cm)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"a":1,"b":2}`, string(data))
+
+	loaded := NewCompactMap[name, int]()
+	assert.Nil(t, json.Unmarshal(data, loaded))
+	assert.True(t, cm.Equal(loaded, nil))
+
+	h := holder{Name: "h", Items: NewCompactMap[int, string]()}
+	h.Items.AddOrSet(2, "two")
+	h.Items.AddOrSet(1, "one")
+	data, err = json.Marshal(h)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"Name":"h","Items":[[1,"one"],[2,"two"]]}`, string(data))
+
+	var decoded holder
+	assert.Nil(t, json.Unmarshal(data, &decoded))
+	assert.True(t, h.Items.Equal(decoded.Items, nil))
+
+	assert.NotNil(t, json.Unmarshal([]byte(`[[1]]`), decoded.Items))
+}
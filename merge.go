@@ -0,0 +1,162 @@
+package compactmap
+
+import (
+	"reflect"
+	"time"
+)
+
+// MapDiff lists keys which differ between two maps, see Diff
+type MapDiff[K any] struct {
+	Added   []K // keys present only in other
+	Removed []K // keys present only in m
+	Changed []K // keys with different values
+}
+
+// Merge copies entries of other into m in one pass over both maps.
+// For keys present in both maps conflict chooses the value, nil conflict takes the value of other.
+// Expiration of merged keys is cleared as with AddOrSet, expired entries of other are skipped.
+func (m *CompactMap[K, V]) Merge(other *CompactMap[K, V], conflict func(key K, old, new V) V) {
+	if m == other {
+		return
+	}
+
+	unlock := lockPair(&m.RWMutex, &other.RWMutex)
+	defer m.unlockWith(unlock)
+
+	now := time.Now().UnixNano()
+	merged := NewCompactMap[K, V]()
+	var sets []Event[K, V]
+
+	a := newCursor(m.buffers)
+	b := newCursor(other.buffers)
+	for a.valid() || b.valid() {
+		if b.valid() && other.isExpired(b.entry().Key, now) {
+			b.next()
+			continue
+		}
+		if !b.valid() || (a.valid() && a.entry().Key < b.entry().Key) {
+			merged.appendSorted(a.entry().Key, a.entry().Value)
+			a.next()
+			continue
+		}
+
+		eb := b.entry()
+		b.next()
+		if !a.valid() || eb.Key < a.entry().Key {
+			merged.appendSorted(eb.Key, eb.Value)
+			sets = append(sets, Event[K, V]{Type: EventSet, Key: eb.Key, New: eb.Value})
+			continue
+		}
+
+		ea := a.entry()
+		a.next()
+		value := eb.Value
+		if conflict != nil && !m.isExpired(ea.Key, now) {
+			value = conflict(ea.Key, ea.Value, eb.Value)
+		}
+		merged.appendSorted(ea.Key, value)
+		sets = append(sets, Event[K, V]{Type: EventSet, Key: ea.Key, Old: ea.Value, HadOld: true, New: value})
+	}
+
+	if len(sets) == 0 {
+		return
+	}
+
+	m.buffers = merged.buffers
+	m.size = merged.size
+	m.changed = true
+	m.mutations.Add(int64(len(sets)))
+
+	for _, e := range sets {
+		if m.expires != nil {
+			m.expires.delete(e.Key)
+		}
+		if len(m.subs) > 0 {
+			m.notify(e)
+		}
+		if m.bounds != nil {
+			m.bounds.bytes += m.bounds.Size(e.Key, e.New)
+			if e.HadOld {
+				m.bounds.bytes -= m.bounds.Size(e.Key, e.Old)
+				m.bounds.Policy.Accessed(e.Key)
+			} else {
+				m.bounds.Policy.Added(e.Key)
+			}
+		}
+	}
+	m.evict()
+}
+
+// Diff compares m with other in one pass, values are compared with reflect.DeepEqual
+func (m *CompactMap[K, V]) Diff(other *CompactMap[K, V]) MapDiff[K] {
+	return m.DiffFunc(other, nil)
+}
+
+// DiffFunc compares m with other in one pass, values are compared with eq
+func (m *CompactMap[K, V]) DiffFunc(other *CompactMap[K, V], eq func(a, b V) bool) MapDiff[K] {
+	var diff MapDiff[K]
+	m.compare(other, eq, func(key K, inM, inOther, equal bool) bool {
+		switch {
+		case !inM:
+			diff.Added = append(diff.Added, key)
+		case !inOther:
+			diff.Removed = append(diff.Removed, key)
+		case !equal:
+			diff.Changed = append(diff.Changed, key)
+		}
+		return true
+	})
+	return diff
+}
+
+// Equal reports whether maps have the same keys and values, nil eq compares values with reflect.DeepEqual
+func (m *CompactMap[K, V]) Equal(other *CompactMap[K, V], eq func(a, b V) bool) bool {
+	equal := true
+	m.compare(other, eq, func(key K, inM, inOther, same bool) bool {
+		equal = inM && inOther && same
+		return equal
+	})
+	return equal
+}
+
+// compare walks both maps in key order skipping expired entries
+// and calls fn for every key until it returns false
+func (m *CompactMap[K, V]) compare(other *CompactMap[K, V], eq func(a, b V) bool, fn func(key K, inM, inOther, equal bool) bool) {
+	if eq == nil {
+		eq = func(a, b V) bool { return reflect.DeepEqual(a, b) }
+	}
+
+	unlock := rlockPair(&m.RWMutex, &other.RWMutex)
+	defer unlock()
+
+	now := time.Now().UnixNano()
+	a := newCursor(m.buffers)
+	b := newCursor(other.buffers)
+	for {
+		for a.valid() && m.isExpired(a.entry().Key, now) {
+			a.next()
+		}
+		for b.valid() && other.isExpired(b.entry().Key, now) {
+			b.next()
+		}
+
+		var ok bool
+		switch {
+		case !a.valid() && !b.valid():
+			return
+		case !b.valid() || (a.valid() && a.entry().Key < b.entry().Key):
+			ok = fn(a.entry().Key, true, false, false)
+			a.next()
+		case !a.valid() || b.entry().Key < a.entry().Key:
+			ok = fn(b.entry().Key, false, true, false)
+			b.next()
+		default:
+			ok = fn(a.entry().Key, true, true, eq(a.entry().Value, b.entry().Value))
+			a.next()
+			b.next()
+		}
+		if !ok {
+			return
+		}
+	}
+}
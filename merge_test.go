@@ -0,0 +1,77 @@
+package compactmap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mapOf(kv ...int) *CompactMap[int, int] {
+	cm := NewCompactMap[int, int]()
+	for i := 0; i < len(kv); i += 2 {
+		cm.AddOrSet(kv[i], kv[i+1])
+	}
+	return cm
+}
+
+func TestMerge(t *testing.T) {
+	a := mapOf(1, 10, 2, 20, 4, 40)
+	b := mapOf(2, 200, 3, 300, 5, 500)
+
+	var events []Event[int, int]
+	a.Subscribe(func(e Event[int, int]) { events = append(events, e) })
+
+	a.Merge(b, func(key, old, new int) int { return old + new })
+	assert.Equal(t, 5, a.Count())
+	v, _ := a.Get(2)
+	assert.Equal(t, 220, v)
+	v, _ = a.Get(3)
+	assert.Equal(t, 300, v)
+	v, _ = a.Get(4)
+	assert.Equal(t, 40, v)
+	assert.Equal(t, 3, len(events))
+	assert.Equal(t, Event[int, int]{Type: EventSet, Key: 2, Old: 20, HadOld: true, New: 220}, events[0])
+
+	// nil conflict takes value of other
+	a.Merge(mapOf(1, 0), nil)
+	v, _ = a.Get(1)
+	assert.Equal(t, 0, v)
+
+	big := NewCompactMap[int, int]()
+	evens := NewCompactMap[int, int]()
+	for i := 0; i < 5000; i++ {
+		if i%2 == 0 {
+			evens.AddOrSet(i, i)
+		} else {
+			big.AddOrSet(i, i)
+		}
+	}
+	big.Merge(evens, nil)
+	assert.Equal(t, 5000, big.Count())
+	prev := -1
+	big.Iterate(func(key, val int) bool {
+		assert.Equal(t, prev+1, key)
+		prev = key
+		return true
+	})
+	big.AddOrSet(-1, -1)
+	assert.True(t, big.Exist(-1))
+}
+
+func TestDiffEqual(t *testing.T) {
+	a := mapOf(1, 10, 2, 20, 3, 30)
+	b := mapOf(2, 20, 3, 31, 4, 40)
+
+	diff := a.Diff(b)
+	assert.Equal(t, []int{4}, diff.Added)
+	assert.Equal(t, []int{1}, diff.Removed)
+	assert.Equal(t, []int{3}, diff.Changed)
+
+	diff = a.DiffFunc(b, func(x, y int) bool { return x/10 == y/10 })
+	assert.Nil(t, diff.Changed)
+
+	assert.False(t, a.Equal(b, nil))
+	assert.True(t, a.Equal(mapOf(3, 30, 2, 20, 1, 10), nil))
+	assert.True(t, a.Equal(a, nil))
+	assert.False(t, a.Equal(mapOf(1, 10, 2, 20), nil))
+}
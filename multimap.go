@@ -0,0 +1,152 @@
+package compactmap
+
+import (
+	"context"
+
+	"golang.org/x/exp/constraints"
+)
+
+/*
+	CompactMultiMap stores several values per key.
+	Pairs (key, value) are kept in the buffers of CompactMapFunc sorted by key and then by value,
+	so values of a key are adjacent and ordered.
+*/
+
+type CompactMultiMap[K constraints.Ordered, V constraints.Ordered] struct {
+	m *CompactMapFunc[Entry[K, V], struct{}]
+}
+
+func compareEntries[K constraints.Ordered, V constraints.Ordered](a, b Entry[K, V]) int {
+	switch {
+	case a.Key < b.Key:
+		return -1
+	case a.Key > b.Key:
+		return 1
+	case a.Value < b.Value:
+		return -1
+	case a.Value > b.Value:
+		return 1
+	}
+	return 0
+}
+
+func NewCompactMultiMap[K constraints.Ordered, V constraints.Ordered]() *CompactMultiMap[K, V] {
+	return &CompactMultiMap[K, V]{
+		m: NewCompactMapFunc[Entry[K, V], struct{}](compareEntries[K, V]),
+	}
+}
+
+// Add adds value to key, returns false if key already has this value
+func (mm *CompactMultiMap[K, V]) Add(key K, value V) bool {
+	return !mm.m.AddOrSet(Entry[K, V]{Key: key, Value: value}, struct{}{})
+}
+
+// GetAll returns values of key in ascending order, nil if key does not exist
+func (mm *CompactMultiMap[K, V]) GetAll(key K) []V {
+	mm.m.RLock()
+	defer mm.m.RUnlock()
+
+	var ret []V
+	mm.iterateKey(key, func(value V) bool {
+		ret = append(ret, value)
+		return true
+	})
+	return ret
+}
+
+// Has reports whether key has any value
+func (mm *CompactMultiMap[K, V]) Has(key K) bool {
+	return mm.CountValues(key) > 0
+}
+
+// HasValue reports whether key has value
+func (mm *CompactMultiMap[K, V]) HasValue(key K, value V) bool {
+	return mm.m.Exist(Entry[K, V]{Key: key, Value: value})
+}
+
+// CountValues returns number of values of key
+func (mm *CompactMultiMap[K, V]) CountValues(key K) int {
+	mm.m.RLock()
+	defer mm.m.RUnlock()
+
+	n := 0
+	mm.iterateKey(key, func(V) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// Count returns total number of values of all keys
+func (mm *CompactMultiMap[K, V]) Count() int {
+	return mm.m.Count()
+}
+
+// Remove removes value of key, returns false if there was no such value
+func (mm *CompactMultiMap[K, V]) Remove(key K, value V) bool {
+	_, loaded := mm.m.LoadAndDelete(Entry[K, V]{Key: key, Value: value})
+	return loaded
+}
+
+// RemoveAll removes all values of key and returns their number
+func (mm *CompactMultiMap[K, V]) RemoveAll(key K) int {
+	mm.m.Lock()
+	defer mm.m.Unlock()
+
+	var values []V
+	mm.iterateKey(key, func(value V) bool {
+		values = append(values, value)
+		return true
+	})
+	for _, value := range values {
+		mm.m.delete(Entry[K, V]{Key: key, Value: value})
+	}
+	return len(values)
+}
+
+func (mm *CompactMultiMap[K, V]) Clear() {
+	mm.m.Clear()
+}
+
+// Iterate calls fn for every pair ordered by key and value until fn returns false.
+// dont modify database in iterate!
+func (mm *CompactMultiMap[K, V]) Iterate(fn func(key K, value V) bool) {
+	mm.m.Iterate(func(e Entry[K, V], _ struct{}) bool {
+		return fn(e.Key, e.Value)
+	})
+}
+
+// iterateKey calls fn for values of key, called under the lock
+func (mm *CompactMultiMap[K, V]) iterateKey(key K, fn func(value V) bool) {
+	mm.m.iterateSeek(func(e Entry[K, V]) bool { return e.Key >= key }, func(e Entry[K, V], _ struct{}) bool {
+		if e.Key != key {
+			return false
+		}
+		return fn(e.Value)
+	})
+}
+
+// Save stores the map into filename, see CompactMap.Save
+func (mm *CompactMultiMap[K, V]) Save(filename string) error {
+	return mm.m.Save(filename)
+}
+
+// SaveContext stores the map into filename, see CompactMap.SaveContext
+func (mm *CompactMultiMap[K, V]) SaveContext(ctx context.Context, filename string, progress ProgressFunc) error {
+	return mm.m.SaveContext(ctx, filename, progress)
+}
+
+// Init loads pairs stored by Save from filename, see CompactMap.Init
+func (mm *CompactMultiMap[K, V]) Init(filename string) error {
+	return mm.m.Init(filename)
+}
+
+// InitContext loads pairs stored by Save from filename, see CompactMap.InitContext
+func (mm *CompactMultiMap[K, V]) InitContext(ctx context.Context, filename string, progress ProgressFunc) error {
+	return mm.m.InitContext(ctx, filename, progress)
+}
+
+// Stats returns short statistics string, see CompactMap.Stats
+func (mm *CompactMultiMap[K, V]) Stats() string {
+	return mm.m.Stats()
+}
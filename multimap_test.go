@@ -0,0 +1,64 @@
+package compactmap
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompactMultiMap(t *testing.T) {
+	mm := NewCompactMultiMap[string, int]()
+	assert.True(t, mm.Add("b", 3))
+	assert.True(t, mm.Add("b", -1))
+	assert.True(t, mm.Add("a", 7))
+	assert.True(t, mm.Add("c", 0))
+	assert.False(t, mm.Add("b", 3))
+
+	assert.Equal(t, []int{-1, 3}, mm.GetAll("b"))
+	assert.Equal(t, []int{7}, mm.GetAll("a"))
+	assert.Nil(t, mm.GetAll("x"))
+	assert.Equal(t, 2, mm.CountValues("b"))
+	assert.Equal(t, 4, mm.Count())
+	assert.True(t, mm.Has("c"))
+	assert.True(t, mm.HasValue("b", -1))
+	assert.False(t, mm.HasValue("b", 0))
+
+	assert.True(t, mm.Remove("b", 3))
+	assert.False(t, mm.Remove("b", 3))
+	assert.Equal(t, []int{-1}, mm.GetAll("b"))
+
+	assert.Equal(t, 1, mm.RemoveAll("a"))
+	assert.Equal(t, 0, mm.RemoveAll("a"))
+	assert.False(t, mm.Has("a"))
+	assert.Equal(t, 2, mm.Count())
+}
+
+func TestCompactMultiMapManyValues(t *testing.T) {
+	mm := NewCompactMultiMap[int, int]()
+	for k := 0; k < 5; k++ {
+		for v := 999; v >= 0; v-- {
+			mm.Add(k, v)
+		}
+	}
+	assert.Equal(t, 5000, mm.Count())
+
+	values := mm.GetAll(3)
+	assert.Equal(t, 1000, len(values))
+	for i, v := range values {
+		assert.Equal(t, i, v)
+	}
+
+	assert.Equal(t, 1000, mm.RemoveAll(2))
+	assert.Equal(t, 4000, mm.Count())
+	assert.Equal(t, 1000, mm.CountValues(1))
+	assert.Equal(t, 1000, mm.CountValues(3))
+
+	file := filepath.Join(t.TempDir(), "multi.dat")
+	assert.Nil(t, mm.Save(file))
+	loaded := NewCompactMultiMap[int, int]()
+	assert.Nil(t, loaded.Init(file))
+	assert.Equal(t, 4000, loaded.Count())
+	assert.Equal(t, values, loaded.GetAll(3))
+	assert.Nil(t, loaded.GetAll(2))
+}
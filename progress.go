@@ -0,0 +1,34 @@
+package compactmap
+
+import "io"
+
+// how often (in entries) SaveContext and InitContext check for cancellation and report progress
+const progressEvery = 10000
+
+// ProgressFunc is called by SaveContext and InitContext with the number of
+// entries processed so far and the number of bytes written or read
+type ProgressFunc func(records, bytes int64)
+
+// countingWriter counts bytes passed to the underlying writer
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingReader counts bytes read from the underlying reader
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
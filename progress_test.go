@@ -0,0 +1,77 @@
+package compactmap
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaveInitProgress(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "progress.dat")
+
+	m := NewCompactMap[int, int]()
+	for i := 0; i < 25000; i++ {
+		m.AddOrSet(i, i*2)
+	}
+
+	var saved, savedBytes int64
+	calls := 0
+	err := m.SaveContext(context.Background(), file, func(records, bytes int64) {
+		saved, savedBytes = records, bytes
+		calls++
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(25000), saved)
+	assert.True(t, calls > 1, "progress should be reported during save")
+
+	st, err := os.Stat(file)
+	assert.Nil(t, err)
+	assert.Equal(t, st.Size(), savedBytes)
+
+	var loaded, loadedBytes int64
+	m2 := NewCompactMap[int, int]()
+	err = m2.InitContext(context.Background(), file, func(records, bytes int64) {
+		loaded, loadedBytes = records, bytes
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(25000), loaded)
+	assert.Equal(t, st.Size(), loadedBytes)
+	assert.Equal(t, 25000, m2.Count())
+
+	v, ok := m2.Get(12345)
+	assert.True(t, ok)
+	assert.Equal(t, 24690, v)
+}
+
+func TestSaveInitCancel(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cancel.dat")
+
+	m := NewCompactMap[int, int]()
+	for i := 0; i < 25000; i++ {
+		m.AddOrSet(i, i)
+	}
+	assert.Nil(t, m.Save(file))
+	m.AddOrSet(-1, -1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := m.SaveContext(ctx, file, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	tmp, _ := filepath.Glob(file + ".*.tmp")
+	assert.Empty(t, tmp, "temporary file should be removed")
+
+	// previous snapshot is untouched
+	m2 := NewCompactMap[int, int]()
+	m2.AddOrSet(-2, -2)
+	err = m2.InitContext(ctx, file, nil)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, m2.Count(), "cancelled load should not change the map")
+
+	assert.Nil(t, m2.Init(file))
+	assert.Equal(t, 25001, m2.Count())
+	assert.False(t, m2.Exist(-1))
+}
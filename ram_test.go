@@ -42,3 +42,57 @@ func TestRamUsage(t *testing.T) {
 
 	fmt.Printf("stats: %s\n", m.Stats())
 }
+
+func TestRamUsageStrings(t *testing.T) {
+	if testing.Short() {
+		t.Skip("fills maps with 10M string keys")
+	}
+
+	var N = 10 * 1000 * 1000
+	key := func(i int) string {
+		return fmt.Sprintf("user:%08d", i)
+	}
+
+	measure := func(name string, fill func() any) {
+		runtime.GC()
+		var was runtime.MemStats
+		runtime.ReadMemStats(&was)
+
+		m := fill()
+
+		runtime.GC()
+		var now runtime.MemStats
+		runtime.ReadMemStats(&now)
+		fmt.Printf("%s used for %d string keys = %v MiB\n", name, N, (now.Alloc-was.Alloc)/1024/1024)
+		runtime.KeepAlive(m)
+	}
+
+	measure("Memory of standard map[string]int32", func() any {
+		m := make(map[string]int32)
+		for i := 0; i < N; i++ {
+			m[key(i)] = int32(i)
+		}
+		return m
+	})
+	measure("CompactMap[string, int32]", func() any {
+		m := NewCompactMap[string, int32]()
+		for i := 0; i < N; i++ {
+			m.AddOrSet(key(i), int32(i))
+		}
+		return m
+	})
+	measure("CompactStringMap[int32]", func() any {
+		m := NewCompactStringMap[int32](false)
+		for i := 0; i < N; i++ {
+			m.AddOrSet(key(i), int32(i))
+		}
+		return m
+	})
+	measure("CompactStringMap[int32] front coded", func() any {
+		m := NewCompactStringMap[int32](true)
+		for i := 0; i < N; i++ {
+			m.AddOrSet(key(i), int32(i))
+		}
+		return m
+	})
+}
@@ -0,0 +1,86 @@
+package compactmap
+
+import (
+	"cmp"
+	"math/rand"
+	"slices"
+)
+
+// RandomEntry returns a uniformly chosen entry, ok is false for an empty map.
+// It walks buffer lengths only, see Sample.
+func (m *CompactMap[K, V]) RandomEntry() (key K, value V, ok bool) {
+	sample := m.Sample(1)
+	if len(sample) == 0 {
+		return key, value, false
+	}
+	return sample[0].Key, sample[0].Value, true
+}
+
+// Sample returns min(n, Count()) distinct uniformly chosen entries in key order.
+// Positions are chosen first and located by buffer lengths, so it takes O(buffers + n log n).
+// Maps with expiring entries are sampled in one pass over all entries instead.
+func (m *CompactMap[K, V]) Sample(n int) []Entry[K, V] {
+	m.RLock()
+	defer m.RUnlock()
+
+	if n <= 0 || m.size == 0 {
+		return nil
+	}
+	if m.expires != nil && len(m.expires.buffers) > 0 {
+		return m.reservoir(n)
+	}
+
+	n = min(n, m.size)
+	positions := samplePositions(m.size, n)
+
+	ret := make([]Entry[K, V], 0, n)
+	start := 0 // position of the first entry of buffer
+	for _, buffer := range m.buffers {
+		end := start + len(*buffer)
+		for len(positions) > 0 && positions[0] < end {
+			ret = append(ret, (*buffer)[positions[0]-start])
+			positions = positions[1:]
+		}
+		if len(positions) == 0 {
+			break
+		}
+		start = end
+	}
+	return ret
+}
+
+// samplePositions returns n distinct sorted numbers from [0, size), Floyd's algorithm
+func samplePositions(size, n int) []int {
+	chosen := make(map[int]struct{}, n)
+	positions := make([]int, 0, n)
+	for j := size - n; j < size; j++ {
+		t := rand.Intn(j + 1)
+		if _, ok := chosen[t]; ok {
+			t = j
+		}
+		chosen[t] = struct{}{}
+		positions = append(positions, t)
+	}
+	slices.Sort(positions)
+	return positions
+}
+
+// reservoir samples not expired entries in one pass, the map should be locked
+func (m *CompactMap[K, V]) reservoir(n int) []Entry[K, V] {
+	var ret []Entry[K, V]
+	seen := 0
+	m.each(func(key K, val V) bool {
+		if len(ret) < n {
+			ret = append(ret, Entry[K, V]{Key: key, Value: val})
+		} else if j := rand.Intn(seen + 1); j < n {
+			ret[j] = Entry[K, V]{Key: key, Value: val}
+		}
+		seen++
+		return true
+	})
+
+	slices.SortFunc(ret, func(a, b Entry[K, V]) int {
+		return cmp.Compare(a.Key, b.Key)
+	})
+	return ret
+}
@@ -0,0 +1,69 @@
+package compactmap
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSample(t *testing.T) {
+	cm := NewCompactMap[int, int]()
+	_, _, ok := cm.RandomEntry()
+	assert.False(t, ok)
+	assert.Empty(t, cm.Sample(5))
+
+	for i := 0; i < 3000; i++ {
+		cm.AddOrSet(i, i*2)
+	}
+
+	key, value, ok := cm.RandomEntry()
+	assert.True(t, ok)
+	assert.Equal(t, key*2, value)
+
+	sample := cm.Sample(100)
+	assert.Len(t, sample, 100)
+	assert.True(t, slices.IsSortedFunc(sample, func(a, b Entry[int, int]) int { return a.Key - b.Key }))
+	for i := 1; i < len(sample); i++ {
+		assert.NotEqual(t, sample[i-1].Key, sample[i].Key, "Sampled keys should be distinct")
+	}
+	for _, e := range sample {
+		assert.Equal(t, e.Key*2, e.Value)
+	}
+	assert.Len(t, cm.Sample(5000), 3000)
+
+	// every buffer is hit, so selection is not biased to the first ones
+	hits := make([]int, 3)
+	for i := 0; i < 3000; i++ {
+		key, _, _ := cm.RandomEntry()
+		hits[key/1000]++
+	}
+	for _, h := range hits {
+		assert.Greater(t, h, 800)
+	}
+}
+
+func TestSampleExpired(t *testing.T) {
+	cm := NewCompactMap[int, int]()
+	for i := 0; i < 100; i++ {
+		cm.AddOrSetWithTTL(i, i, time.Nanosecond)
+	}
+	for i := 100; i < 110; i++ {
+		cm.AddOrSet(i, i)
+	}
+	time.Sleep(time.Millisecond)
+
+	sample := cm.Sample(20)
+	assert.Len(t, sample, 10, "Expired entries should not be sampled")
+	for i, e := range sample {
+		assert.Equal(t, 100+i, e.Key)
+	}
+
+	sample = cm.Sample(3)
+	assert.Len(t, sample, 3)
+	assert.True(t, slices.IsSortedFunc(sample, func(a, b Entry[int, int]) int { return a.Key - b.Key }))
+	for _, e := range sample {
+		assert.GreaterOrEqual(t, e.Key, 100)
+	}
+}
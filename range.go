@@ -0,0 +1,99 @@
+package compactmap
+
+import (
+	"sort"
+	"time"
+)
+
+// IterateFrom calls fn for entries with key >= from in key order until fn returns false.
+// dont modify database in iterate!
+func (m *CompactMap[K, V]) IterateFrom(from K, fn func(key K, val V) bool) {
+	m.RLock()
+	defer m.RUnlock()
+
+	m.iterateFrom(from, fn)
+}
+
+// IterateRange calls fn for entries with from <= key < to in key order until fn returns false.
+// dont modify database in iterate!
+func (m *CompactMap[K, V]) IterateRange(from, to K, fn func(key K, val V) bool) {
+	m.RLock()
+	defer m.RUnlock()
+
+	m.iterateFrom(from, func(key K, val V) bool {
+		if key >= to {
+			return false
+		}
+		return fn(key, val)
+	})
+}
+
+func (m *CompactMap[K, V]) iterateFrom(from K, fn func(key K, val V) bool) {
+	checkExpired := m.expires != nil && len(m.expires.buffers) > 0
+	now := time.Now().UnixNano()
+
+	bufferIndex, index, _ := m.find(from)
+	for ; bufferIndex < len(m.buffers); bufferIndex++ {
+		buffer := *m.buffers[bufferIndex]
+		for ; index < len(buffer); index++ {
+			e := buffer[index]
+			if checkExpired && m.isExpired(e.Key, now) {
+				continue
+			}
+			if !fn(e.Key, e.Value) {
+				return
+			}
+		}
+		index = 0
+	}
+}
+
+// IterateFrom calls fn for entries with key >= from in key order until fn returns false.
+// dont modify database in iterate!
+func (m *CompactMapFunc[K, V]) IterateFrom(from K, fn func(key K, val V) bool) {
+	m.RLock()
+	defer m.RUnlock()
+
+	m.iterateFrom(from, fn)
+}
+
+// IterateRange calls fn for entries with from <= key < to in key order until fn returns false.
+// dont modify database in iterate!
+func (m *CompactMapFunc[K, V]) IterateRange(from, to K, fn func(key K, val V) bool) {
+	m.RLock()
+	defer m.RUnlock()
+
+	m.iterateFrom(from, func(key K, val V) bool {
+		if m.cmp(key, to) >= 0 {
+			return false
+		}
+		return fn(key, val)
+	})
+}
+
+func (m *CompactMapFunc[K, V]) iterateFrom(from K, fn func(key K, val V) bool) {
+	m.iterateSeek(func(key K) bool { return m.cmp(key, from) >= 0 }, fn)
+}
+
+// iterateSeek iterates from the first key for which ge returns true,
+// ge should be false for keys before some point and true after it
+func (m *CompactMapFunc[K, V]) iterateSeek(ge func(key K) bool, fn func(key K, val V) bool) {
+	bufferIndex := sort.Search(len(m.buffers), func(i int) bool {
+		buffer := *m.buffers[i]
+		return ge(buffer[len(buffer)-1].Key)
+	})
+	index := 0
+	if bufferIndex < len(m.buffers) {
+		buffer := *m.buffers[bufferIndex]
+		index = sort.Search(len(buffer), func(i int) bool { return ge(buffer[i].Key) })
+	}
+	for ; bufferIndex < len(m.buffers); bufferIndex++ {
+		buffer := *m.buffers[bufferIndex]
+		for ; index < len(buffer); index++ {
+			if !fn(buffer[index].Key, buffer[index].Value) {
+				return
+			}
+		}
+		index = 0
+	}
+}
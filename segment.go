@@ -0,0 +1,321 @@
+package compactmap
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/exp/constraints"
+)
+
+/*
+	Segment file of TieredMap:
+
+	magic | blocks | index | index offset (8 bytes)
+
+	Every block is gob encoded sorted entries of one buffer, up to maxSliceSize.
+	The index keeps the first and the last key of every block, so lookups read one block.
+	Segments are immutable, they are only replaced by compaction.
+*/
+
+var segmentMagic = [8]byte{'C', 'M', 'A', 'P', 'S', 'E', 'G', '1'}
+
+var ErrBadSegment = errors.New("compactmap: bad segment file")
+
+// tieredValue is a stored value or a tombstone of a deleted key
+type tieredValue[V any] struct {
+	Value   V
+	Deleted bool
+}
+
+type segmentBlock[K any] struct {
+	First, Last K
+	Offset      int64
+	Size        uint32
+	CRC         uint32
+}
+
+type segmentIndex[K any] struct {
+	Count  int // entries of the whole map when the segment was written
+	Blocks []segmentBlock[K]
+}
+
+// segment is an open segment file, from and to are sequence numbers of flushes merged into it
+type segment[K constraints.Ordered, V any] struct {
+	file     *os.File
+	path     string
+	from, to uint64
+	index    segmentIndex[K]
+}
+
+func segmentName(from, to uint64) string {
+	return fmt.Sprintf("%016d-%016d.seg", from, to)
+}
+
+func parseSegmentName(name string) (from, to uint64, ok bool) {
+	a, b, _ := strings.Cut(strings.TrimSuffix(name, ".seg"), "-")
+	from, err1 := strconv.ParseUint(a, 10, 64)
+	to, err2 := strconv.ParseUint(b, 10, 64)
+	return from, to, err1 == nil && err2 == nil && name == segmentName(from, to)
+}
+
+// writeSegment writes entries of src into dir, tombstones are skipped if dropDeleted is set
+func writeSegment[K constraints.Ordered, V any](dir string, from, to uint64, src tieredSource[K, V], dropDeleted bool, count int) (*segment[K, V], error) {
+	path := filepath.Join(dir, segmentName(from, to))
+
+	err := saveFile(path, func(file *os.File) error {
+		w := bufio.NewWriter(file)
+		if _, err := w.Write(segmentMagic[:]); err != nil {
+			return err
+		}
+		offset := int64(len(segmentMagic))
+		index := segmentIndex[K]{Count: count}
+
+		block := make([]Entry[K, tieredValue[V]], 0, maxSliceSize)
+		writeBlock := func() error {
+			if len(block) == 0 {
+				return nil
+			}
+			data, err := Serialize(block)
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+			index.Blocks = append(index.Blocks, segmentBlock[K]{
+				First:  block[0].Key,
+				Last:   block[len(block)-1].Key,
+				Offset: offset,
+				Size:   uint32(len(data)),
+				CRC:    crc32.Checksum(data, crcTable),
+			})
+			offset += int64(len(data))
+			block = block[:0]
+			return nil
+		}
+
+		for ; src.valid(); src.next() {
+			e := src.entry()
+			if dropDeleted && e.Value.Deleted {
+				continue
+			}
+			block = append(block, e)
+			if len(block) == maxSliceSize {
+				if err := writeBlock(); err != nil {
+					return err
+				}
+			}
+		}
+		if err := src.err(); err != nil {
+			return err
+		}
+		if err := writeBlock(); err != nil {
+			return err
+		}
+
+		data, err := Serialize(index)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		var buf8 [8]byte
+		binary.LittleEndian.PutUint64(buf8[:], uint64(offset))
+		if _, err := w.Write(buf8[:]); err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		return file.Sync()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return openSegment[K, V](path)
+}
+
+func openSegment[K constraints.Ordered, V any](path string) (*segment[K, V], error) {
+	from, to, ok := parseSegmentName(filepath.Base(path))
+	if !ok {
+		return nil, ErrBadSegment
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &segment[K, V]{file: file, path: path, from: from, to: to}
+	if err := s.readIndex(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+func (s *segment[K, V]) readIndex() error {
+	st, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	size := st.Size()
+	if size < int64(len(segmentMagic))+8 {
+		return ErrBadSegment
+	}
+
+	var magic [8]byte
+	if _, err := s.file.ReadAt(magic[:], 0); err != nil {
+		return err
+	}
+	var buf8 [8]byte
+	if _, err := s.file.ReadAt(buf8[:], size-8); err != nil {
+		return err
+	}
+	offset := int64(binary.LittleEndian.Uint64(buf8[:]))
+	if magic != segmentMagic || offset < int64(len(segmentMagic)) || offset > size-8 {
+		return ErrBadSegment
+	}
+
+	data := make([]byte, size-8-offset)
+	if _, err := s.file.ReadAt(data, offset); err != nil {
+		return err
+	}
+	s.index, err = Deserialize[segmentIndex[K]](data)
+	return err
+}
+
+func (s *segment[K, V]) readBlock(i int) ([]Entry[K, tieredValue[V]], error) {
+	b := s.index.Blocks[i]
+	data := make([]byte, b.Size)
+	if _, err := s.file.ReadAt(data, b.Offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if crc32.Checksum(data, crcTable) != b.CRC {
+		return nil, fmt.Errorf("%s: block %d: %w", s.path, i, ErrBadSegment)
+	}
+	return Deserialize[[]Entry[K, tieredValue[V]]](data)
+}
+
+// get looks key up reading at most one block through cache
+func (s *segment[K, V]) get(key K, cache *blockCache[K, V]) (tieredValue[V], bool, error) {
+	var zero tieredValue[V]
+	blocks := s.index.Blocks
+	i := sort.Search(len(blocks), func(i int) bool {
+		return blocks[i].Last >= key
+	})
+	if i == len(blocks) || blocks[i].First > key {
+		return zero, false, nil
+	}
+
+	entries, err := cache.get(s, i)
+	if err != nil {
+		return zero, false, err
+	}
+	j := sort.Search(len(entries), func(j int) bool {
+		return entries[j].Key >= key
+	})
+	if j == len(entries) || entries[j].Key != key {
+		return zero, false, nil
+	}
+	return entries[j].Value, true, nil
+}
+
+// blockCache keeps recently read blocks of segments
+type blockCache[K constraints.Ordered, V any] struct {
+	mu       sync.Mutex
+	capacity int
+	lru      *list.List // front is the most recently used
+	items    map[blockKey[K, V]]*list.Element
+}
+
+type blockKey[K constraints.Ordered, V any] struct {
+	seg   *segment[K, V]
+	block int
+}
+
+type cachedBlock[K constraints.Ordered, V any] struct {
+	key     blockKey[K, V]
+	entries []Entry[K, tieredValue[V]]
+}
+
+func newBlockCache[K constraints.Ordered, V any](capacity int) *blockCache[K, V] {
+	return &blockCache[K, V]{
+		capacity: capacity,
+		lru:      list.New(),
+		items:    make(map[blockKey[K, V]]*list.Element),
+	}
+}
+
+func (c *blockCache[K, V]) get(s *segment[K, V], block int) ([]Entry[K, tieredValue[V]], error) {
+	key := blockKey[K, V]{seg: s, block: block}
+
+	c.mu.Lock()
+	if el, ok := c.items[key]; ok {
+		c.lru.MoveToFront(el)
+		c.mu.Unlock()
+		return el.Value.(*cachedBlock[K, V]).entries, nil
+	}
+	c.mu.Unlock()
+
+	entries, err := s.readBlock(block)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.items[key]; !ok && c.capacity > 0 {
+		c.items[key] = c.lru.PushFront(&cachedBlock[K, V]{key: key, entries: entries})
+		c.shrink()
+	}
+	return entries, nil
+}
+
+// drop removes blocks of segment s
+func (c *blockCache[K, V]) drop(s *segment[K, V]) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := range s.index.Blocks {
+		if el, ok := c.items[blockKey[K, V]{seg: s, block: i}]; ok {
+			c.lru.Remove(el)
+			delete(c.items, el.Value.(*cachedBlock[K, V]).key)
+		}
+	}
+}
+
+func (c *blockCache[K, V]) setCapacity(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.capacity = n
+	c.shrink()
+}
+
+func (c *blockCache[K, V]) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len()
+}
+
+func (c *blockCache[K, V]) shrink() {
+	for c.lru.Len() > max(c.capacity, 0) {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.items, el.Value.(*cachedBlock[K, V]).key)
+	}
+}
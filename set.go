@@ -0,0 +1,136 @@
+package compactmap
+
+import (
+	"context"
+
+	"golang.org/x/exp/constraints"
+)
+
+/*
+	CompactSet is a sorted set of keys stored in the same buffers as CompactMap.
+	Union, Intersect and Difference are linear merges of sorted buffers.
+*/
+
+type CompactSet[K constraints.Ordered] struct {
+	m *CompactMap[K, struct{}]
+}
+
+func NewCompactSet[K constraints.Ordered]() *CompactSet[K] {
+	return &CompactSet[K]{m: NewCompactMap[K, struct{}]()}
+}
+
+// Add adds key, returns false if it was already in the set
+func (s *CompactSet[K]) Add(key K) bool {
+	return !s.m.AddOrSet(key, struct{}{})
+}
+
+// Remove removes key, returns false if it was not in the set
+func (s *CompactSet[K]) Remove(key K) bool {
+	_, loaded := s.m.LoadAndDelete(key)
+	return loaded
+}
+
+func (s *CompactSet[K]) Has(key K) bool {
+	return s.m.Exist(key)
+}
+
+func (s *CompactSet[K]) Len() int {
+	return s.m.Count()
+}
+
+func (s *CompactSet[K]) Clear() {
+	s.m.Clear()
+}
+
+// Iterate calls fn for keys in ascending order until fn returns false.
+// dont modify set in iterate!
+func (s *CompactSet[K]) Iterate(fn func(key K) bool) {
+	s.m.Iterate(func(key K, _ struct{}) bool {
+		return fn(key)
+	})
+}
+
+// IterateRange calls fn for keys from <= key < to in ascending order until fn returns false
+func (s *CompactSet[K]) IterateRange(from, to K, fn func(key K) bool) {
+	s.m.IterateRange(from, to, func(key K, _ struct{}) bool {
+		return fn(key)
+	})
+}
+
+// Union returns new set with keys of both sets
+func (s *CompactSet[K]) Union(other *CompactSet[K]) *CompactSet[K] {
+	return s.merge(other, true, true, true)
+}
+
+// Intersect returns new set with keys present in both sets
+func (s *CompactSet[K]) Intersect(other *CompactSet[K]) *CompactSet[K] {
+	return s.merge(other, false, true, false)
+}
+
+// Difference returns new set with keys of s which are not in other
+func (s *CompactSet[K]) Difference(other *CompactSet[K]) *CompactSet[K] {
+	return s.merge(other, true, false, false)
+}
+
+// merge walks both sets in order and keeps keys present only in s, in both or only in other
+func (s *CompactSet[K]) merge(other *CompactSet[K], onlyS, both, onlyOther bool) *CompactSet[K] {
+	unlock := rlockPair(&s.m.RWMutex, &other.m.RWMutex)
+	defer unlock()
+
+	ret := NewCompactSet[K]()
+	a := newCursor(s.m.buffers)
+	b := newCursor(other.m.buffers)
+	for a.valid() && b.valid() {
+		ka, kb := a.entry().Key, b.entry().Key
+		switch {
+		case ka < kb:
+			if onlyS {
+				ret.m.appendSorted(ka, struct{}{})
+			}
+			a.next()
+		case ka > kb:
+			if onlyOther {
+				ret.m.appendSorted(kb, struct{}{})
+			}
+			b.next()
+		default:
+			if both {
+				ret.m.appendSorted(ka, struct{}{})
+			}
+			a.next()
+			b.next()
+		}
+	}
+	for ; onlyS && a.valid(); a.next() {
+		ret.m.appendSorted(a.entry().Key, struct{}{})
+	}
+	for ; onlyOther && b.valid(); b.next() {
+		ret.m.appendSorted(b.entry().Key, struct{}{})
+	}
+	return ret
+}
+
+// Save stores the set into filename, see CompactMap.Save
+func (s *CompactSet[K]) Save(filename string) error {
+	return s.m.Save(filename)
+}
+
+// SaveContext stores the set into filename, see CompactMap.SaveContext
+func (s *CompactSet[K]) SaveContext(ctx context.Context, filename string, progress ProgressFunc) error {
+	return s.m.SaveContext(ctx, filename, progress)
+}
+
+// Init loads keys stored by Save from filename, see CompactMap.Init
+func (s *CompactSet[K]) Init(filename string) error {
+	return s.m.Init(filename)
+}
+
+// InitContext loads keys stored by Save from filename, see CompactMap.InitContext
+func (s *CompactSet[K]) InitContext(ctx context.Context, filename string, progress ProgressFunc) error {
+	return s.m.InitContext(ctx, filename, progress)
+}
+
+// Stats returns short statistics string, see CompactMap.Stats
+func (s *CompactSet[K]) Stats() string {
+	return s.m.Stats()
+}
@@ -0,0 +1,82 @@
+package compactmap
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setOf(keys ...int) *CompactSet[int] {
+	s := NewCompactSet[int]()
+	for _, k := range keys {
+		s.Add(k)
+	}
+	return s
+}
+
+func keysOf(s *CompactSet[int]) []int {
+	var ret []int
+	s.Iterate(func(key int) bool {
+		ret = append(ret, key)
+		return true
+	})
+	return ret
+}
+
+func TestCompactSet(t *testing.T) {
+	s := NewCompactSet[int]()
+	assert.True(t, s.Add(3))
+	assert.True(t, s.Add(1))
+	assert.False(t, s.Add(3))
+	assert.True(t, s.Has(1))
+	assert.False(t, s.Has(2))
+	assert.Equal(t, 2, s.Len())
+	assert.Equal(t, []int{1, 3}, keysOf(s))
+
+	assert.True(t, s.Remove(1))
+	assert.False(t, s.Remove(1))
+	assert.Equal(t, 1, s.Len())
+}
+
+func TestCompactSetAlgebra(t *testing.T) {
+	a := setOf(1, 2, 3, 5, 8)
+	b := setOf(2, 3, 4, 8, 9)
+
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 8, 9}, keysOf(a.Union(b)))
+	assert.Equal(t, []int{2, 3, 8}, keysOf(a.Intersect(b)))
+	assert.Equal(t, []int{1, 5}, keysOf(a.Difference(b)))
+	assert.Equal(t, []int{4, 9}, keysOf(b.Difference(a)))
+	assert.Equal(t, keysOf(a), keysOf(a.Union(a)))
+	assert.Nil(t, keysOf(a.Difference(a)))
+
+	big := NewCompactSet[int]()
+	evens := NewCompactSet[int]()
+	for i := 0; i < 5000; i++ {
+		big.Add(i)
+		if i%2 == 0 {
+			evens.Add(i)
+		}
+	}
+	odds := big.Difference(evens)
+	assert.Equal(t, 2500, odds.Len())
+	assert.True(t, odds.Has(4999))
+	assert.False(t, odds.Has(0))
+	assert.Equal(t, 5000, odds.Union(evens).Len())
+	assert.Equal(t, 0, odds.Intersect(evens).Len())
+
+	// merged sets are usable for further updates
+	odds.Add(0)
+	assert.Equal(t, 2501, odds.Len())
+	assert.Equal(t, 0, keysOf(odds)[0])
+}
+
+func TestCompactSetSaveInit(t *testing.T) {
+	s := setOf(5, 1, 9, 3)
+	file := filepath.Join(t.TempDir(), "set.dat")
+	assert.Nil(t, s.Save(file))
+
+	loaded := NewCompactSet[int]()
+	assert.Nil(t, loaded.Init(file))
+	assert.Equal(t, []int{1, 3, 5, 9}, keysOf(loaded))
+}
@@ -0,0 +1,642 @@
+package compactmap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+/*
+	Snapshot file layout (all numbers are little endian):
+
+	header:  magic [8]byte, flags uint32,
+	         for encrypted snapshots followed by key id size uint16, key id and random file id [16]byte
+	chunks:  chunk payloads one after another
+	index:   chunks count uint32, then for every chunk
+	         offset uint64, size uint32, entries uint32, crc32 uint32
+	trailer: index offset uint64, total entries uint64, magic [8]byte
+
+	Chunk payload is a sequence of entries, every entry is
+	key size uint32, gob key, value size uint32, gob value
+	and, if the snapshot has expiry flag, expiration time in unix nanoseconds int64 (0 - never).
+
+	Encrypted snapshots store every chunk and the index sealed with AES-GCM,
+	see encrypt.go.
+
+	Chunks are encoded and decoded independently, so Save and Init can use all cores.
+	Files without the magic header are read in the old sequential format.
+*/
+
+var snapshotMagic = [8]byte{'C', 'M', 'A', 'P', 'S', 'N', 'P', '2'}
+
+var ErrBadSnapshot = errors.New("compactmap: bad snapshot file")
+
+const (
+	snapshotHeaderSize  = 8 + 4
+	snapshotTrailerSize = 8 + 8 + 8
+	snapshotIndexEntry  = 8 + 4 + 4 + 4
+	snapshotFileIDSize  = 16
+
+	// buffers per chunk
+	chunkBuffers = 16
+
+	flagEncrypted = 1
+	flagExpiry    = 2
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+type chunkInfo struct {
+	offset  uint64
+	size    uint32
+	entries uint32
+	crc     uint32
+}
+
+// SetWorkers sets how many goroutines encode and decode snapshot chunks in Save and Init.
+// n <= 0 means runtime.GOMAXPROCS(0), 1 processes chunks sequentially.
+func (m *CompactMap[K, V]) SetWorkers(n int) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.workers = n
+}
+
+func (m *CompactMap[K, V]) getWorkers() int {
+	return workersOrDefault(m.workers)
+}
+
+func workersOrDefault(n int) int {
+	if n <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return n
+}
+
+// saveFile writes a temporary file next to filename by write and renames it to filename,
+// so a failed save does not damage the previous snapshot.
+// Every call has its own temporary file, concurrent saves do not remove each other's files.
+func saveFile(filename string, write func(file *os.File) error) error {
+	file, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := file.Name()
+	if err := file.Chmod(0644); err != nil {
+		file.Close()
+		os.Remove(tmpName)
+		return err
+	}
+
+	err = write(file)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, filename)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+	}
+	return err
+}
+
+// chunks groups buffers for independent encoding
+func (m *CompactMap[K, V]) chunks() [][][]Entry[K, V] {
+	return chunksOf(m.buffers)
+}
+
+func chunksOf[K any, V any](buffers []*[]Entry[K, V]) [][][]Entry[K, V] {
+	var chunks [][][]Entry[K, V]
+	var cur [][]Entry[K, V]
+	for _, buffer := range buffers {
+		if buffer == nil || len(*buffer) == 0 {
+			continue
+		}
+		cur = append(cur, *buffer)
+		if len(cur) == chunkBuffers {
+			chunks = append(chunks, cur)
+			cur = nil
+		}
+	}
+	if len(cur) > 0 {
+		chunks = append(chunks, cur)
+	}
+	return chunks
+}
+
+// encodeChunk writes expiration time after every value if expiry is set,
+// entries expired at now are skipped
+func encodeChunk[K any, V any](chunk [][]Entry[K, V], expiry func(key K) int64, now int64) ([]byte, int, error) {
+	var out bytes.Buffer
+	var buf4 [4]byte
+	var buf8 [8]byte
+	count := 0
+
+	for _, buffer := range chunk {
+		for _, entry := range buffer {
+			var exp int64
+			if expiry != nil {
+				exp = expiry(entry.Key)
+				if exp != 0 && exp <= now {
+					continue
+				}
+			}
+
+			keyData, err := Serialize(entry.Key)
+			if err != nil {
+				return nil, 0, err
+			}
+			valueData, err := Serialize(entry.Value)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			binary.LittleEndian.PutUint32(buf4[:], uint32(len(keyData)))
+			out.Write(buf4[:])
+			out.Write(keyData)
+
+			binary.LittleEndian.PutUint32(buf4[:], uint32(len(valueData)))
+			out.Write(buf4[:])
+			out.Write(valueData)
+
+			if expiry != nil {
+				binary.LittleEndian.PutUint64(buf8[:], uint64(exp))
+				out.Write(buf8[:])
+			}
+
+			count++
+		}
+	}
+	return out.Bytes(), count, nil
+}
+
+// decodeChunk returns entries and their expiration times if withExpiry is set
+func decodeChunk[K any, V any](data []byte, entries int, withExpiry bool) ([]Entry[K, V], []int64, error) {
+	// every entry takes at least two size prefixes, the count from the index is not trusted
+	if entries < 0 || entries > len(data)/8 {
+		return nil, nil, ErrBadSnapshot
+	}
+
+	ret := make([]Entry[K, V], 0, entries)
+	var expires []int64
+	if withExpiry {
+		expires = make([]int64, 0, entries)
+	}
+
+	next := func() ([]byte, error) {
+		if len(data) < 4 {
+			return nil, ErrBadSnapshot
+		}
+		size := binary.LittleEndian.Uint32(data)
+		data = data[4:]
+		if uint64(len(data)) < uint64(size) {
+			return nil, ErrBadSnapshot
+		}
+		ret := data[:size]
+		data = data[size:]
+		return ret, nil
+	}
+
+	for i := 0; i < entries; i++ {
+		keyData, err := next()
+		if err != nil {
+			return nil, nil, err
+		}
+		key, err := Deserialize[K](keyData)
+		if err != nil {
+			return nil, nil, err
+		}
+		valueData, err := next()
+		if err != nil {
+			return nil, nil, err
+		}
+		value, err := Deserialize[V](valueData)
+		if err != nil {
+			return nil, nil, err
+		}
+		ret = append(ret, Entry[K, V]{Key: key, Value: value})
+
+		if withExpiry {
+			if len(data) < 8 {
+				return nil, nil, ErrBadSnapshot
+			}
+			expires = append(expires, int64(binary.LittleEndian.Uint64(data)))
+			data = data[8:]
+		}
+	}
+
+	if len(data) != 0 {
+		return nil, nil, ErrBadSnapshot
+	}
+	return ret, expires, nil
+}
+
+// parallelOrdered runs produce(i) for every i in [0,n) on up to workers goroutines
+// and passes the results to consume strictly in order of i.
+// At most workers results are kept in memory at once.
+func parallelOrdered[T any](ctx context.Context, n, workers int, produce func(i int) (T, error), consume func(i int, res T) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	type result struct {
+		res T
+		err error
+	}
+
+	results := make([]chan result, n)
+	for i := range results {
+		results[i] = make(chan result, 1)
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, workers)
+	done := make(chan struct{})
+
+	// producers may still read map buffers, wait for them before returning
+	defer wg.Wait()
+	defer close(done)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i++ {
+			select {
+			case sem <- struct{}{}:
+			case <-done:
+				return
+			}
+			if ctx.Err() != nil {
+				results[i] <- result{err: ctx.Err()}
+				continue
+			}
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				res, err := produce(i)
+				results[i] <- result{res: res, err: err}
+			}(i)
+		}
+	}()
+
+	for i := 0; i < n; i++ {
+		r := <-results[i]
+		<-sem
+		if r.err != nil {
+			return r.err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := consume(i, r.res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotOptions are passed from the map to snapshot readers and writers
+type snapshotOptions struct {
+	workers  int
+	progress ProgressFunc
+	keys     KeyProvider // nil for plain snapshots
+}
+
+func (m *CompactMap[K, V]) snapshotOptions(progress ProgressFunc) snapshotOptions {
+	return snapshotOptions{
+		workers:  m.getWorkers(),
+		progress: progress,
+		keys:     m.keys,
+	}
+}
+
+// writeSnapshot writes chunks into file, expiry is nil if entries have no expiration time
+func writeSnapshot[K any, V any](ctx context.Context, file io.Writer, chunks [][][]Entry[K, V], expiry func(key K) int64, opts snapshotOptions) error {
+	return writeSnapshotFunc(ctx, file, len(chunks), func(i int) [][]Entry[K, V] { return chunks[i] }, expiry, opts)
+}
+
+// writeSnapshotFunc writes n chunks returned by chunk, which is called concurrently
+func writeSnapshotFunc[K any, V any](ctx context.Context, file io.Writer, n int, chunk func(i int) [][]Entry[K, V], expiry func(key K) int64, opts snapshotOptions) error {
+	counter := &countingWriter{w: file}
+	writer := bufio.NewWriterSize(counter, 4*1024*1024)
+
+	header := make([]byte, snapshotHeaderSize)
+	copy(header, snapshotMagic[:])
+
+	var flags uint32
+	if expiry != nil {
+		flags |= flagExpiry
+	}
+
+	var aead *snapshotCipher
+	if opts.keys != nil {
+		id, key, err := opts.keys.CurrentKey()
+		if err != nil {
+			return err
+		}
+		if len(id) > math.MaxUint16 {
+			return fmt.Errorf("compactmap: key id is too long")
+		}
+		flags |= flagEncrypted
+		header = binary.LittleEndian.AppendUint16(header, uint16(len(id)))
+		header = append(header, id...)
+
+		fileID := make([]byte, snapshotFileIDSize)
+		if _, err := rand.Read(fileID); err != nil {
+			return err
+		}
+		header = append(header, fileID...)
+
+		aead, err = newSnapshotCipher(key, header)
+		if err != nil {
+			return err
+		}
+	}
+
+	binary.LittleEndian.PutUint32(header[8:], flags)
+	if _, err := writer.Write(header); err != nil {
+		return err
+	}
+
+	now := time.Now().UnixNano()
+	index := make([]chunkInfo, n)
+	offset := uint64(len(header))
+	var records int64
+
+	type encoded struct {
+		data    []byte
+		entries int
+	}
+
+	err := parallelOrdered(ctx, n, opts.workers,
+		func(i int) (encoded, error) {
+			data, entries, err := encodeChunk(chunk(i), expiry, now)
+			if err != nil {
+				return encoded{}, err
+			}
+			if aead != nil {
+				data, err = aead.sealChunk(i, data)
+			}
+			return encoded{data: data, entries: entries}, err
+		},
+		func(i int, e encoded) error {
+			if _, err := writer.Write(e.data); err != nil {
+				return err
+			}
+			index[i] = chunkInfo{
+				offset:  offset,
+				size:    uint32(len(e.data)),
+				entries: uint32(e.entries),
+				crc:     crc32.Checksum(e.data, crcTable),
+			}
+			offset += uint64(len(e.data))
+			records += int64(e.entries)
+			if opts.progress != nil {
+				opts.progress(records, counter.n+int64(writer.Buffered()))
+			}
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+
+	indexData := make([]byte, 4+len(index)*snapshotIndexEntry)
+	binary.LittleEndian.PutUint32(indexData, uint32(len(index)))
+	p := indexData[4:]
+	for _, ci := range index {
+		binary.LittleEndian.PutUint64(p[0:], ci.offset)
+		binary.LittleEndian.PutUint32(p[8:], ci.size)
+		binary.LittleEndian.PutUint32(p[12:], ci.entries)
+		binary.LittleEndian.PutUint32(p[16:], ci.crc)
+		p = p[snapshotIndexEntry:]
+	}
+
+	var trailer [snapshotTrailerSize]byte
+	binary.LittleEndian.PutUint64(trailer[0:], offset)
+	binary.LittleEndian.PutUint64(trailer[8:], uint64(records))
+	copy(trailer[16:], snapshotMagic[:])
+
+	if aead != nil {
+		indexData, err = aead.sealIndex(indexData, trailer[:])
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := writer.Write(indexData); err != nil {
+		return err
+	}
+	if _, err := writer.Write(trailer[:]); err != nil {
+		return err
+	}
+
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if opts.progress != nil {
+		opts.progress(records, counter.n)
+	}
+	return nil
+}
+
+// isSnapshot reports whether file starts with the chunked snapshot header
+func isSnapshot(file io.ReaderAt) bool {
+	var magic [8]byte
+	if _, err := file.ReadAt(magic[:], 0); err != nil {
+		return false
+	}
+	return magic == snapshotMagic
+}
+
+// readSnapshotHeader returns raw header bytes, flags and the cipher for encrypted snapshots
+func readSnapshotHeader(file io.ReaderAt, size int64, keys KeyProvider) ([]byte, uint32, *snapshotCipher, error) {
+	if size < snapshotHeaderSize+snapshotTrailerSize+4 {
+		return nil, 0, nil, ErrBadSnapshot
+	}
+
+	header := make([]byte, snapshotHeaderSize)
+	if _, err := file.ReadAt(header, 0); err != nil {
+		return nil, 0, nil, err
+	}
+
+	flags := binary.LittleEndian.Uint32(header[8:])
+	if flags&^(flagEncrypted|flagExpiry) != 0 {
+		return nil, 0, nil, ErrBadSnapshot
+	}
+	if flags&flagEncrypted == 0 {
+		if keys != nil {
+			return nil, 0, nil, ErrNotEncrypted
+		}
+		return header, flags, nil, nil
+	}
+	if keys == nil {
+		return nil, 0, nil, ErrEncrypted
+	}
+
+	var idLen [2]byte
+	if _, err := file.ReadAt(idLen[:], snapshotHeaderSize); err != nil {
+		return nil, 0, nil, err
+	}
+	id := make([]byte, binary.LittleEndian.Uint16(idLen[:]))
+	fileID := make([]byte, snapshotFileIDSize)
+	if int64(len(header)+2+len(id)+len(fileID)) > size {
+		return nil, 0, nil, ErrBadSnapshot
+	}
+	if _, err := file.ReadAt(id, snapshotHeaderSize+2); err != nil {
+		return nil, 0, nil, err
+	}
+	if _, err := file.ReadAt(fileID, snapshotHeaderSize+2+int64(len(id))); err != nil {
+		return nil, 0, nil, err
+	}
+	header = append(header, idLen[:]...)
+	header = append(header, id...)
+	header = append(header, fileID...)
+
+	key, err := keys.Key(string(id))
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	aead, err := newSnapshotCipher(key, header)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	return header, flags, aead, nil
+}
+
+func readSnapshotIndex(file io.ReaderAt, size int64, headerSize int, aead *snapshotCipher) ([]chunkInfo, error) {
+	var trailer [snapshotTrailerSize]byte
+	if _, err := file.ReadAt(trailer[:], size-snapshotTrailerSize); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(trailer[16:], snapshotMagic[:]) {
+		return nil, ErrBadSnapshot
+	}
+
+	indexOffset := binary.LittleEndian.Uint64(trailer[0:])
+	totalEntries := binary.LittleEndian.Uint64(trailer[8:])
+	if indexOffset < uint64(headerSize) || indexOffset > uint64(size-snapshotTrailerSize-4) {
+		return nil, ErrBadSnapshot
+	}
+
+	indexData := make([]byte, uint64(size-snapshotTrailerSize)-indexOffset)
+	if _, err := file.ReadAt(indexData, int64(indexOffset)); err != nil {
+		return nil, err
+	}
+
+	if aead != nil {
+		var err error
+		indexData, err = aead.openIndex(indexData, trailer[:])
+		if err != nil {
+			return nil, err
+		}
+		if len(indexData) < 4 {
+			return nil, ErrBadSnapshot
+		}
+	}
+
+	count := binary.LittleEndian.Uint32(indexData)
+	if uint64(len(indexData)) != 4+uint64(count)*snapshotIndexEntry {
+		return nil, ErrBadSnapshot
+	}
+
+	index := make([]chunkInfo, count)
+	p := indexData[4:]
+	var entries uint64
+	for i := range index {
+		ci := chunkInfo{
+			offset:  binary.LittleEndian.Uint64(p[0:]),
+			size:    binary.LittleEndian.Uint32(p[8:]),
+			entries: binary.LittleEndian.Uint32(p[12:]),
+			crc:     binary.LittleEndian.Uint32(p[16:]),
+		}
+		if ci.offset < uint64(headerSize) || ci.offset+uint64(ci.size) > indexOffset {
+			return nil, ErrBadSnapshot
+		}
+		entries += uint64(ci.entries)
+		index[i] = ci
+		p = p[snapshotIndexEntry:]
+	}
+	if entries != totalEntries {
+		return nil, ErrBadSnapshot
+	}
+	return index, nil
+}
+
+// expiredAt reports whether entry i of a chunk passed by readSnapshot has expired at now
+func expiredAt(expires []int64, i int, now int64) bool {
+	return expires != nil && expires[i] != 0 && expires[i] <= now
+}
+
+type decodedChunk[K any, V any] struct {
+	entries []Entry[K, V]
+	expires []int64
+}
+
+// readSnapshot decodes chunks in parallel and passes them to fn in file order.
+// expires is nil if the snapshot has no expiration times.
+func readSnapshot[K any, V any](ctx context.Context, file io.ReaderAt, size int64, opts snapshotOptions, fn func(entries []Entry[K, V], expires []int64)) error {
+	header, flags, aead, err := readSnapshotHeader(file, size, opts.keys)
+	if err != nil {
+		return err
+	}
+	withExpiry := flags&flagExpiry != 0
+
+	index, err := readSnapshotIndex(file, size, len(header), aead)
+	if err != nil {
+		return err
+	}
+
+	var records int64
+	read := int64(len(header))
+	err = parallelOrdered(ctx, len(index), opts.workers,
+		func(i int) (decodedChunk[K, V], error) {
+			ci := index[i]
+			data := make([]byte, ci.size)
+			if _, err := file.ReadAt(data, int64(ci.offset)); err != nil {
+				return decodedChunk[K, V]{}, err
+			}
+			if aead != nil {
+				// authenticated by GCM together with the file id and chunk number,
+				// chunks of other snapshots fail here
+				var err error
+				data, err = aead.openChunk(i, data)
+				if err != nil {
+					return decodedChunk[K, V]{}, err
+				}
+			} else if crc32.Checksum(data, crcTable) != ci.crc {
+				return decodedChunk[K, V]{}, fmt.Errorf("%w: chunk %d checksum mismatch", ErrBadSnapshot, i)
+			}
+			entries, expires, err := decodeChunk[K, V](data, int(ci.entries), withExpiry)
+			return decodedChunk[K, V]{entries: entries, expires: expires}, err
+		},
+		func(i int, c decodedChunk[K, V]) error {
+			fn(c.entries, c.expires)
+			records += int64(len(c.entries))
+			read += int64(index[i].size)
+			if opts.progress != nil {
+				opts.progress(records, read)
+			}
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+
+	if opts.progress != nil {
+		opts.progress(records, size)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package compactmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotParallelMatchesSequential(t *testing.T) {
+	dir := t.TempDir()
+
+	m := NewCompactMap[int, string]()
+	for i := 0; i < 100000; i++ {
+		m.AddOrSet(i*7%100003, fmt.Sprintf("value %d", i))
+	}
+
+	m.SetWorkers(1)
+	assert.Nil(t, m.Save(filepath.Join(dir, "seq.dat")))
+	m.SetWorkers(8)
+	assert.Nil(t, m.Save(filepath.Join(dir, "par.dat")))
+
+	seq, err := os.ReadFile(filepath.Join(dir, "seq.dat"))
+	assert.Nil(t, err)
+	par, err := os.ReadFile(filepath.Join(dir, "par.dat"))
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(seq, par), "parallel snapshot should be identical to sequential one")
+
+	m1 := NewCompactMap[int, string]()
+	m1.SetWorkers(1)
+	assert.Nil(t, m1.Init(filepath.Join(dir, "seq.dat")))
+	m2 := NewCompactMap[int, string]()
+	m2.SetWorkers(8)
+	assert.Nil(t, m2.Init(filepath.Join(dir, "par.dat")))
+
+	assert.Equal(t, m.Count(), m1.Count())
+	assert.Equal(t, m.Count(), m2.Count())
+
+	var all1, all2 []Entry[int, string]
+	m1.Iterate(func(k int, v string) bool {
+		all1 = append(all1, Entry[int, string]{k, v})
+		return true
+	})
+	m2.Iterate(func(k int, v string) bool {
+		all2 = append(all2, Entry[int, string]{k, v})
+		return true
+	})
+	assert.Equal(t, all1, all2)
+
+	m.Iterate(func(k int, v string) bool {
+		v2, ok := m2.Get(k)
+		assert.True(t, ok)
+		assert.Equal(t, v, v2)
+		return ok
+	})
+}
+
+func TestSnapshotLegacyFormat(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "legacy.dat")
+
+	// sequential format written by previous versions
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint64(3))
+	for i := int32(1); i <= 3; i++ {
+		k, _ := Serialize(i)
+		v, _ := Serialize(i * 10)
+		binary.Write(&buf, binary.LittleEndian, uint32(len(k)))
+		buf.Write(k)
+		binary.Write(&buf, binary.LittleEndian, uint32(len(v)))
+		buf.Write(v)
+	}
+	assert.Nil(t, os.WriteFile(file, buf.Bytes(), 0644))
+
+	m := NewCompactMap[int32, int32]()
+	assert.Nil(t, m.Init(file))
+	assert.Equal(t, 3, m.Count())
+	v, ok := m.Get(2)
+	assert.True(t, ok)
+	assert.Equal(t, int32(20), v)
+}
+
+func TestSnapshotLegacyBadSize(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "legacy.dat")
+
+	for _, size := range []uint32{0xFFFFFFFF, 1000} {
+		var buf bytes.Buffer
+		binary.Write(&buf, binary.LittleEndian, uint64(1))
+		binary.Write(&buf, binary.LittleEndian, size)
+		buf.Write([]byte{1, 2, 3})
+		assert.Nil(t, os.WriteFile(file, buf.Bytes(), 0644))
+
+		m := NewCompactMap[int32, int32]()
+		assert.ErrorIs(t, m.Init(file), ErrBadSnapshot)
+		assert.Equal(t, 0, m.Count())
+	}
+}
+
+func TestSnapshotBadEntryCount(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "count.dat")
+
+	m := NewCompactMap[int, int]()
+	for i := 0; i < 100; i++ {
+		m.AddOrSet(i, i)
+	}
+	assert.Nil(t, m.Save(file))
+
+	// entry count of the only chunk and the total in the trailer, neither is covered by checksums
+	data, err := os.ReadFile(file)
+	assert.Nil(t, err)
+	trailer := data[len(data)-snapshotTrailerSize:]
+	indexOffset := binary.LittleEndian.Uint64(trailer)
+	binary.LittleEndian.PutUint32(data[indexOffset+4+12:], 0x7FFFFFFF)
+	binary.LittleEndian.PutUint64(trailer[8:], 0x7FFFFFFF)
+	assert.Nil(t, os.WriteFile(file, data, 0644))
+
+	m2 := NewCompactMap[int, int]()
+	assert.ErrorIs(t, m2.Init(file), ErrBadSnapshot)
+	assert.Equal(t, 0, m2.Count())
+}
+
+func TestSnapshotCorrupted(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "corrupted.dat")
+
+	m := NewCompactMap[int, int]()
+	for i := 0; i < 1000; i++ {
+		m.AddOrSet(i, i)
+	}
+	assert.Nil(t, m.Save(file))
+
+	data, err := os.ReadFile(file)
+	assert.Nil(t, err)
+	data[snapshotHeaderSize+10] ^= 0xff
+	assert.Nil(t, os.WriteFile(file, data, 0644))
+
+	m2 := NewCompactMap[int, int]()
+	err = m2.Init(file)
+	assert.ErrorIs(t, err, ErrBadSnapshot)
+	assert.Equal(t, 0, m2.Count())
+
+	// truncated file
+	assert.Nil(t, os.WriteFile(file, data[:len(data)-5], 0644))
+	assert.ErrorIs(t, m2.Init(file), ErrBadSnapshot)
+}
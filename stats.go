@@ -0,0 +1,172 @@
+package compactmap
+
+import (
+	"reflect"
+	"time"
+	"unsafe"
+)
+
+// StatsInfo describes buffers and memory usage of the map
+type StatsInfo struct {
+	Buffers int
+	Entries int // stored entries, including expired but not yet removed
+
+	MinBufferLen int
+	MaxBufferLen int
+	AvgBufferLen float64
+
+	// FillHistogram counts buffers by fill factor (length / max buffer length):
+	// [0] - less than 10%, [1] - 10..20%, ... [9] - 90..100%
+	FillHistogram [10]int
+
+	// estimated heap memory used by keys and values,
+	// including allocated but unused buffer capacity and data referenced by strings, slices and pointers
+	KeyBytes   int64
+	ValueBytes int64
+
+	// Fragmentation is the share of allocated buffer capacity not used by entries
+	Fragmentation float64
+
+	Dirty      bool      // changed since the last Save or Init
+	LastSave   time.Time // zero if never saved
+	LoadedFile string    // file of the last Save or Init
+}
+
+// StatsInfo collects statistics of buffers and memory usage,
+// it walks all entries if keys or values reference heap data
+func (m *CompactMap[K, V]) StatsInfo() StatsInfo {
+	m.RLock()
+	defer m.RUnlock()
+
+	info := statsOf(m.buffers)
+	info.Dirty = m.changed
+	info.LastSave = m.lastSave
+	info.LoadedFile = m.loadedFile
+	return info
+}
+
+func statsOf[K any, V any](buffers []*[]Entry[K, V]) StatsInfo {
+	info := StatsInfo{
+		Buffers: len(buffers),
+	}
+
+	var zero Entry[K, V]
+	keySize := int64(unsafe.Sizeof(zero.Key))
+	entrySize := int64(unsafe.Sizeof(zero))
+	keyDynamic := hasHeapData(reflect.TypeOf(&zero.Key).Elem())
+	valueDynamic := hasHeapData(reflect.TypeOf(&zero.Value).Elem())
+
+	seen := make(map[unsafe.Pointer]bool)
+	var capacity int64
+	for i, buffer := range buffers {
+		l := len(*buffer)
+		info.Entries += l
+		if i == 0 || l < info.MinBufferLen {
+			info.MinBufferLen = l
+		}
+		if l > info.MaxBufferLen {
+			info.MaxBufferLen = l
+		}
+		info.FillHistogram[min(l*10/maxSliceSize, 9)]++
+
+		c := int64(cap(*buffer))
+		capacity += c
+		// padding of Entry is counted as value memory
+		info.KeyBytes += c * keySize
+		info.ValueBytes += c * (entrySize - keySize)
+
+		if keyDynamic || valueDynamic {
+			for _, e := range *buffer {
+				if keyDynamic {
+					info.KeyBytes += heapSize(reflect.ValueOf(&e.Key).Elem(), seen)
+				}
+				if valueDynamic {
+					info.ValueBytes += heapSize(reflect.ValueOf(&e.Value).Elem(), seen)
+				}
+			}
+		}
+	}
+
+	if info.Buffers > 0 {
+		info.AvgBufferLen = float64(info.Entries) / float64(info.Buffers)
+	}
+	if capacity > 0 {
+		info.Fragmentation = float64(capacity-int64(info.Entries)) / float64(capacity)
+	}
+	return info
+}
+
+// hasHeapData reports whether values of type t may reference heap memory
+func hasHeapData(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Slice, reflect.Pointer, reflect.Map, reflect.Interface, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return true
+	case reflect.Array:
+		return hasHeapData(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if hasHeapData(t.Field(i).Type) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// heapSize estimates heap memory referenced by v, not counting v itself.
+// Data behind pointers is counted once, seen tracks visited pointers.
+func heapSize(v reflect.Value, seen map[unsafe.Pointer]bool) int64 {
+	switch v.Kind() {
+	case reflect.String:
+		return int64(v.Len())
+	case reflect.Slice:
+		if v.IsNil() {
+			return 0
+		}
+		size := int64(v.Cap()) * int64(v.Type().Elem().Size())
+		if hasHeapData(v.Type().Elem()) {
+			for i := 0; i < v.Len(); i++ {
+				size += heapSize(v.Index(i), seen)
+			}
+		}
+		return size
+	case reflect.Pointer:
+		if v.IsNil() || seen[v.UnsafePointer()] {
+			return 0
+		}
+		seen[v.UnsafePointer()] = true
+		return int64(v.Type().Elem().Size()) + heapSize(v.Elem(), seen)
+	case reflect.Interface:
+		if v.IsNil() {
+			return 0
+		}
+		e := v.Elem()
+		return int64(e.Type().Size()) + heapSize(e, seen)
+	case reflect.Map:
+		if v.IsNil() {
+			return 0
+		}
+		t := v.Type()
+		size := int64(v.Len()) * int64(t.Key().Size()+t.Elem().Size())
+		iter := v.MapRange()
+		for iter.Next() {
+			size += heapSize(iter.Key(), seen) + heapSize(iter.Value(), seen)
+		}
+		return size
+	case reflect.Array:
+		var size int64
+		if hasHeapData(v.Type().Elem()) {
+			for i := 0; i < v.Len(); i++ {
+				size += heapSize(v.Index(i), seen)
+			}
+		}
+		return size
+	case reflect.Struct:
+		var size int64
+		for i := 0; i < v.NumField(); i++ {
+			size += heapSize(v.Field(i), seen)
+		}
+		return size
+	}
+	return 0
+}
@@ -0,0 +1,62 @@
+package compactmap
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatsInfo(t *testing.T) {
+	cm := NewCompactMap[int64, int64]()
+	for i := int64(0); i < 2500; i++ {
+		cm.AddOrSet(i, i)
+	}
+
+	info := cm.StatsInfo()
+	assert.Equal(t, 3, info.Buffers)
+	assert.Equal(t, 2500, info.Entries)
+	assert.Equal(t, 500, info.MinBufferLen)
+	assert.Equal(t, 1000, info.MaxBufferLen)
+	assert.InDelta(t, 833.33, info.AvgBufferLen, 0.01)
+	assert.Equal(t, [10]int{5: 1, 9: 2}, info.FillHistogram)
+	assert.True(t, info.KeyBytes >= 2500*8)
+	assert.True(t, info.ValueBytes >= 2500*8)
+	assert.True(t, info.Fragmentation >= 0 && info.Fragmentation < 1)
+	assert.True(t, info.Dirty)
+	assert.True(t, info.LastSave.IsZero())
+
+	assert.Equal(t, "3 buffers, total len: 2500", cm.Stats())
+
+	file := filepath.Join(t.TempDir(), "stats.dat")
+	assert.Nil(t, cm.Save(file))
+	info = cm.StatsInfo()
+	assert.False(t, info.Dirty)
+	assert.False(t, info.LastSave.IsZero())
+	assert.Equal(t, file, info.LoadedFile)
+}
+
+func TestStatsInfoHeapData(t *testing.T) {
+	type value struct {
+		Name string
+		Tags []string
+		Next *value
+	}
+
+	cm := NewCompactMap[string, *value]()
+	shared := &value{Name: strings.Repeat("s", 100)}
+	shared.Next = shared // cycle
+	for i := 0; i < 10; i++ {
+		cm.AddOrSet(strings.Repeat("k", 50)+string(rune('a'+i)), shared)
+	}
+
+	info := cm.StatsInfo()
+	assert.True(t, info.KeyBytes >= 10*51, "Key bytes should include string data")
+	assert.True(t, info.ValueBytes >= 100, "Value bytes should include referenced data")
+	assert.True(t, info.ValueBytes < 1000, "Shared value should be counted once")
+
+	empty := NewCompactMap[int, int]().StatsInfo()
+	assert.Equal(t, 0, empty.Buffers)
+	assert.Equal(t, float64(0), empty.Fragmentation)
+}
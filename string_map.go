@@ -0,0 +1,434 @@
+package compactmap
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+	CompactStringMap is CompactMap for string keys which stores keys of every buffer
+	in one byte arena instead of separate heap strings.
+	With front coding keys are stored as the length of prefix shared with the previous key
+	and the rest, which saves more memory for keys with common prefixes, but makes inserts slower.
+	Iterate and IterateFrom pass copies of keys.
+*/
+
+type stringBuffer[V any] struct {
+	keys   keyArena
+	values []V
+}
+
+type CompactStringMap[V any] struct {
+	sync.RWMutex
+
+	frontCoded bool
+	buffers    []*stringBuffer[V]
+	size       int // number of stored entries
+	changed    bool
+	saveMu     sync.Mutex // one Save or Init at a time
+	loadedFile string
+	lastSave   time.Time
+
+	workers int         // snapshot encoding goroutines, see SetWorkers
+	keys    KeyProvider // snapshot encryption, see SetEncryption
+}
+
+// NewCompactStringMap creates map storing keys in arenas, front coded if frontCoded is set
+func NewCompactStringMap[V any](frontCoded bool) *CompactStringMap[V] {
+	return &CompactStringMap[V]{
+		frontCoded: frontCoded,
+		buffers:    make([]*stringBuffer[V], 0, 100),
+	}
+}
+
+func (m *CompactStringMap[V]) newBuffer() *stringBuffer[V] {
+	if m.frontCoded {
+		return &stringBuffer[V]{keys: &frontArena{}}
+	}
+	return &stringBuffer[V]{keys: &plainArena{}}
+}
+
+func (m *CompactStringMap[V]) Clear() {
+	m.Lock()
+	defer m.Unlock()
+
+	m.buffers = m.buffers[:0]
+	m.size = 0
+	m.changed = true
+}
+
+// sync.Map analog
+func (m *CompactStringMap[V]) LoadOrStore(key string, value V) (old V, loaded bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	old, loaded = m.get(key)
+	if loaded {
+		return old, true
+	}
+
+	m.addOrSet(key, value)
+	return value, false
+}
+
+func (m *CompactStringMap[V]) LoadAndDelete(key string) (old V, loaded bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	old, loaded = m.get(key)
+	if loaded {
+		m.delete(key)
+	}
+	return old, loaded
+}
+
+// sync.map - compatible
+func (m *CompactStringMap[V]) Store(key string, value V) {
+	m.AddOrSet(key, value)
+}
+
+// Add or Set
+func (m *CompactStringMap[V]) AddOrSet(key string, value V) (overwrited bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.addOrSet(key, value)
+}
+
+// find returns buffer index and position of key in it, see CompactMap.find
+func (m *CompactStringMap[V]) find(key string) (bufferIndex, index int, found bool) {
+	n := len(m.buffers)
+	if n == 0 {
+		return 0, 0, false
+	}
+
+	bufferIndex = sort.Search(n, func(i int) bool {
+		return string(m.buffers[i].keys.last()) >= key
+	})
+	if bufferIndex == n {
+		bufferIndex = n - 1
+	}
+	index, found = m.buffers[bufferIndex].keys.search(key)
+	return bufferIndex, index, found
+}
+
+func (m *CompactStringMap[V]) addOrSet(key string, value V) (overwrited bool) {
+	m.changed = true
+
+	if len(m.buffers) == 0 {
+		buffer := m.newBuffer()
+		buffer.keys.insert(0, key)
+		buffer.values = append(buffer.values, value)
+		m.buffers = append(m.buffers, buffer)
+		m.size++
+		return false
+	}
+
+	bufferIndex, index, found := m.find(key)
+	buffer := m.buffers[bufferIndex]
+	if found {
+		buffer.values[index] = value
+		return true
+	}
+
+	m.size++
+
+	switch kind, half := planInsert(buffer.keys.len(), index); kind {
+	case insertNewBuffer:
+		newBuffer := m.newBuffer()
+		newBuffer.keys.insert(0, key)
+		newBuffer.values = append(newBuffer.values, value)
+		m.buffers = slices.Insert(m.buffers, bufferIndex+1, newBuffer)
+		return false
+	case insertSplit:
+		right := &stringBuffer[V]{keys: buffer.keys.split(), values: slices.Clone(buffer.values[half:])}
+		clear(buffer.values[half:])
+		buffer.values = buffer.values[:half]
+		m.buffers = slices.Insert(m.buffers, bufferIndex+1, right)
+
+		if index > half {
+			buffer = right
+			index -= half
+		}
+	}
+
+	buffer.keys.insert(index, key)
+	buffer.values = slices.Insert(buffer.values, index, value)
+	return false
+}
+
+// alias map-compatible
+func (m *CompactStringMap[V]) Load(key string) (V, bool) {
+	return m.Get(key)
+}
+
+func (m *CompactStringMap[V]) Get(key string) (V, bool) {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.get(key)
+}
+
+func (m *CompactStringMap[V]) get(key string) (V, bool) {
+	bufferIndex, index, found := m.find(key)
+	if !found {
+		var zero V
+		return zero, false
+	}
+	return m.buffers[bufferIndex].values[index], true
+}
+
+func (m *CompactStringMap[V]) Delete(key string) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.delete(key)
+}
+
+func (m *CompactStringMap[V]) delete(key string) {
+	bufferIndex, index, found := m.find(key)
+	if !found {
+		return
+	}
+
+	buffer := m.buffers[bufferIndex]
+	buffer.keys.remove(index)
+	buffer.values = slices.Delete(buffer.values, index, index+1)
+	m.size--
+	m.changed = true
+
+	if buffer.keys.len() == 0 {
+		//remove whole slice
+		m.buffers = slices.Delete(m.buffers, bufferIndex, bufferIndex+1)
+	}
+}
+
+// sync.Map alias
+func (m *CompactStringMap[V]) Range(fn func(key string, val V) bool) {
+	m.Iterate(fn)
+}
+
+// dont modify database in iterate!
+func (m *CompactStringMap[V]) Iterate(fn func(key string, val V) bool) {
+	m.RLock()
+	defer m.RUnlock()
+
+	m.iterateFrom(0, 0, fn)
+}
+
+// IterateFrom calls fn for entries with key >= from in key order until fn returns false.
+// dont modify database in iterate!
+func (m *CompactStringMap[V]) IterateFrom(from string, fn func(key string, val V) bool) {
+	m.RLock()
+	defer m.RUnlock()
+
+	bufferIndex, index, _ := m.find(from)
+	m.iterateFrom(bufferIndex, index, fn)
+}
+
+// IterateRange calls fn for entries with from <= key < to in key order until fn returns false.
+// dont modify database in iterate!
+func (m *CompactStringMap[V]) IterateRange(from, to string, fn func(key string, val V) bool) {
+	m.RLock()
+	defer m.RUnlock()
+
+	bufferIndex, index, _ := m.find(from)
+	m.iterateFrom(bufferIndex, index, func(key string, val V) bool {
+		if key >= to {
+			return false
+		}
+		return fn(key, val)
+	})
+}
+
+func (m *CompactStringMap[V]) iterateFrom(bufferIndex, index int, fn func(key string, val V) bool) {
+	for ; bufferIndex < len(m.buffers); bufferIndex++ {
+		buffer := m.buffers[bufferIndex]
+		ok := buffer.keys.each(index, func(i int, key []byte) bool {
+			return fn(string(key), buffer.values[i])
+		})
+		if !ok {
+			return
+		}
+		index = 0
+	}
+}
+
+func (m *CompactStringMap[V]) Exist(key string) bool {
+	m.RLock()
+	defer m.RUnlock()
+
+	_, _, found := m.find(key)
+	return found
+}
+
+func (m *CompactStringMap[V]) Count() int {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.size
+}
+
+// KeyBytes returns memory used by key arenas
+func (m *CompactStringMap[V]) KeyBytes() int {
+	m.RLock()
+	defer m.RUnlock()
+
+	n := 0
+	for _, buffer := range m.buffers {
+		n += buffer.keys.bytes()
+	}
+	return n
+}
+
+func (m *CompactStringMap[V]) Stats() string {
+	m.RLock()
+	defer m.RUnlock()
+
+	return fmt.Sprintf("%d buffers, total len: %d", len(m.buffers), m.size)
+}
+
+// SetWorkers sets how many goroutines encode and decode snapshot chunks, see CompactMap.SetWorkers
+func (m *CompactStringMap[V]) SetWorkers(n int) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.workers = n
+}
+
+// SetEncryption enables snapshot encryption, see CompactMap.SetEncryption
+func (m *CompactStringMap[V]) SetEncryption(keys KeyProvider) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.keys = keys
+	m.changed = true
+}
+
+func (m *CompactStringMap[V]) snapshotOptions(progress ProgressFunc) snapshotOptions {
+	return snapshotOptions{
+		workers:  workersOrDefault(m.workers),
+		progress: progress,
+		keys:     m.keys,
+	}
+}
+
+// chunk returns entries of i-th group of chunkBuffers buffers
+func (m *CompactStringMap[V]) chunk(i int) [][]Entry[string, V] {
+	buffers := m.buffers[i*chunkBuffers : min(len(m.buffers), (i+1)*chunkBuffers)]
+	chunk := make([][]Entry[string, V], len(buffers))
+	for j, buffer := range buffers {
+		entries := make([]Entry[string, V], 0, buffer.keys.len())
+		buffer.keys.each(0, func(i int, key []byte) bool {
+			entries = append(entries, Entry[string, V]{Key: string(key), Value: buffer.values[i]})
+			return true
+		})
+		chunk[j] = entries
+	}
+	return chunk
+}
+
+// Save stores the map into filename, see SaveContext
+func (m *CompactStringMap[V]) Save(filename string) error {
+	return m.SaveContext(context.Background(), filename, nil)
+}
+
+// SaveContext stores the map into filename in the format of CompactMap[string, V], see CompactMap.SaveContext
+func (m *CompactStringMap[V]) SaveContext(ctx context.Context, filename string, progress ProgressFunc) error {
+	m.saveMu.Lock()
+	defer m.saveMu.Unlock()
+
+	// changes made while the file is written mark the map changed again
+	m.Lock()
+	if m.loadedFile == filename && !m.changed {
+		m.Unlock()
+		return nil
+	}
+	m.changed = false
+	m.Unlock()
+
+	m.RLock()
+	n := (len(m.buffers) + chunkBuffers - 1) / chunkBuffers
+	err := saveFile(filename, func(file *os.File) error {
+		return writeSnapshotFunc[string, V](ctx, file, n, m.chunk, nil, m.snapshotOptions(progress))
+	})
+	m.RUnlock()
+
+	m.Lock()
+	defer m.Unlock()
+
+	if err != nil {
+		m.changed = true
+		return err
+	}
+	m.loadedFile = filename
+	m.lastSave = time.Now()
+	return nil
+}
+
+// Init loads entries stored by Save from filename, see InitContext
+func (m *CompactStringMap[V]) Init(filename string) error {
+	return m.InitContext(context.Background(), filename, nil)
+}
+
+// InitContext loads entries stored by Save from filename, see CompactMap.InitContext.
+// Entries already expired are skipped, expiration times of the others are not kept.
+func (m *CompactStringMap[V]) InitContext(ctx context.Context, filename string, progress ProgressFunc) error {
+	m.saveMu.Lock()
+	defer m.saveMu.Unlock()
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	st, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	m.RLock()
+	opts := m.snapshotOptions(progress)
+	m.RUnlock()
+
+	if !isSnapshot(file) {
+		return ErrBadSnapshot
+	}
+
+	loaded := NewCompactStringMap[V](m.frontCoded)
+	now := time.Now().UnixNano()
+	err = readSnapshot(ctx, file, st.Size(), opts, func(entries []Entry[string, V], expires []int64) {
+		for i, entry := range entries {
+			if expiredAt(expires, i, now) {
+				continue
+			}
+			loaded.addOrSet(entry.Key, entry.Value)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	if len(m.buffers) == 0 {
+		m.buffers = loaded.buffers
+		m.size = loaded.size
+	} else {
+		loaded.iterateFrom(0, 0, func(key string, val V) bool {
+			m.addOrSet(key, val)
+			return true
+		})
+	}
+
+	m.changed = false
+	m.loadedFile = filename
+	return nil
+}
@@ -0,0 +1,117 @@
+package compactmap
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testStringMap(t *testing.T, frontCoded bool) {
+	cm := NewCompactStringMap[int](frontCoded)
+	ref := make(map[string]int)
+
+	for i := 0; i < 20000; i++ {
+		key := fmt.Sprintf("user:%d:item:%d", rand.Intn(300), rand.Intn(100))
+		if rand.Intn(4) == 0 {
+			cm.Delete(key)
+			delete(ref, key)
+			continue
+		}
+		_, exists := ref[key]
+		assert.Equal(t, exists, cm.AddOrSet(key, i))
+		ref[key] = i
+	}
+	cm.AddOrSet("", -1)
+	ref[""] = -1
+
+	assert.Equal(t, len(ref), cm.Count())
+	for key, val := range ref {
+		v, ok := cm.Get(key)
+		assert.True(t, ok, key)
+		assert.Equal(t, val, v)
+	}
+	assert.False(t, cm.Exist("user:"))
+
+	keys := make([]string, 0, len(ref))
+	for key := range ref {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var got []string
+	cm.Iterate(func(key string, val int) bool {
+		got = append(got, key)
+		return true
+	})
+	assert.Equal(t, keys, got)
+
+	got = got[:0]
+	cm.IterateRange("user:10:", "user:11:", func(key string, val int) bool {
+		got = append(got, key)
+		return true
+	})
+	var want []string
+	for _, key := range keys {
+		if key >= "user:10:" && key < "user:11:" {
+			want = append(want, key)
+		}
+	}
+	assert.Equal(t, want, got)
+
+	file := filepath.Join(t.TempDir(), "strings.dat")
+	assert.Nil(t, cm.Save(file))
+
+	loaded := NewCompactStringMap[int](frontCoded)
+	assert.Nil(t, loaded.Init(file))
+	assert.Equal(t, cm.Count(), loaded.Count())
+	v, _ := loaded.Get(keys[len(keys)/2])
+	assert.Equal(t, ref[keys[len(keys)/2]], v)
+
+	// format is the same as of CompactMap[string, V]
+	plain := NewCompactMap[string, int]()
+	assert.Nil(t, plain.Init(file))
+	assert.Equal(t, cm.Count(), plain.Count())
+}
+
+func TestCompactStringMap(t *testing.T) {
+	testStringMap(t, false)
+}
+
+func TestCompactStringMapFrontCoded(t *testing.T) {
+	testStringMap(t, true)
+}
+
+func TestCompactStringMapInitSkipsExpired(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ttl.dat")
+	saveExpiring(t, file, func(i int) string { return fmt.Sprint("key", i) }, 1)
+
+	loaded := NewCompactStringMap[int](true)
+	assert.Nil(t, loaded.Init(file))
+	assert.Equal(t, 5, loaded.Count())
+	assert.True(t, loaded.Exist("key2"))
+	assert.False(t, loaded.Exist("key3"), "Expired key should not be loaded")
+}
+
+func TestFrontCodingSavesMemory(t *testing.T) {
+	plain := NewCompactStringMap[int](false)
+	front := NewCompactStringMap[int](true)
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprintf("https://example.com/users/%08d", i)
+		plain.AddOrSet(key, i)
+		front.AddOrSet(key, i)
+	}
+	assert.True(t, front.KeyBytes() < plain.KeyBytes()/2)
+}
+
+func TestCompactStringMapConcurrentSave(t *testing.T) {
+	cm := NewCompactStringMap[int](true)
+	testConcurrentSave(t, func(i int) { cm.AddOrSet(fmt.Sprint(i), i) }, cm.Save, func(file string) int {
+		loaded := NewCompactStringMap[int](true)
+		assert.NoError(t, loaded.Init(file))
+		return loaded.Count()
+	})
+}
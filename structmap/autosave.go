@@ -0,0 +1,149 @@
+package structmap
+
+import (
+	"sync"
+	"time"
+
+	"github.com/goupdate/compactmap"
+)
+
+type autoSaver struct {
+	minChanges int64
+	onError    func(error)
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+
+	mu    sync.Mutex
+	stats compactmap.AutoSaveStats
+}
+
+// halt stops the saving goroutine and waits until it exits
+func (s *autoSaver) halt() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		<-s.done
+	})
+}
+
+// EnableAutoSave saves the storage files every interval
+// if entries were changed at least minChanges times since the last save.
+// onError, if not nil, is called with errors of background saves.
+// Calling it again replaces the previous settings, see Close for the final save.
+// It panics if interval is not positive.
+func (p *StructMap[V]) EnableAutoSave(interval time.Duration, minChanges int, onError func(error)) {
+	if interval <= 0 {
+		panic("structmap: non-positive autosave interval")
+	}
+
+	s := &autoSaver{
+		minChanges: int64(max(minChanges, 1)),
+		onError:    onError,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	p.Lock()
+	old := p.autosave
+	p.autosave = s
+	p.Unlock()
+
+	if old != nil {
+		old.halt()
+	}
+
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				p.autoSave(s, false)
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// DisableAutoSave stops background saves without saving pending changes
+func (p *StructMap[V]) DisableAutoSave() {
+	p.RLock()
+	s := p.autosave
+	p.RUnlock()
+
+	if s != nil {
+		s.halt()
+	}
+}
+
+// AutoSaveStats returns counters of saves made by EnableAutoSave and Close
+func (p *StructMap[V]) AutoSaveStats() compactmap.AutoSaveStats {
+	p.RLock()
+	s := p.autosave
+	p.RUnlock()
+
+	var stats compactmap.AutoSaveStats
+	if s != nil {
+		s.mu.Lock()
+		stats = s.stats
+		s.mu.Unlock()
+	}
+	stats.Pending = p.pending()
+	return stats
+}
+
+// pending returns the number of changes not saved into the storage file
+func (p *StructMap[V]) pending() int64 {
+	p.RLock()
+	defer p.RUnlock()
+
+	return p.cm.Mutations() + p.unsaved
+}
+
+// Close stops background saves and saves the storage files
+func (p *StructMap[V]) Close() error {
+	p.Lock()
+	s := p.autosave
+	p.Unlock()
+
+	if s == nil {
+		s = &autoSaver{}
+	} else {
+		s.halt()
+	}
+	if err := p.autoSave(s, true); err != nil {
+		return err
+	}
+	return p.cm.Close()
+}
+
+// autoSave saves storage files if entries have enough changes, force saves them anyway
+func (p *StructMap[V]) autoSave(s *autoSaver, force bool) error {
+	if pending := p.pending(); !force && (pending == 0 || pending < s.minChanges) {
+		return nil
+	}
+
+	start := time.Now()
+	err := p.Save()
+
+	s.mu.Lock()
+	s.stats.LastSave = start
+	s.stats.LastDuration = time.Since(start)
+	s.stats.LastError = err
+	if err != nil {
+		s.stats.Errors++
+	} else {
+		s.stats.Saves++
+	}
+	s.mu.Unlock()
+
+	if err != nil && !force && s.onError != nil {
+		s.onError(err)
+	}
+	return err
+}
@@ -0,0 +1,73 @@
+package structmap
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAutoSave(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "autosave_storage")
+
+	storage, err := New[*ExampleStruct](file, false)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	storage.EnableAutoSave(10*time.Millisecond, 2, nil)
+
+	storage.Add(&ExampleStruct{Field1: "a"})
+	storage.Add(&ExampleStruct{Field1: "b"})
+
+	deadline := time.Now().Add(time.Second)
+	for storage.AutoSaveStats().Saves == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected background save")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	id := storage.Add(&ExampleStruct{Field1: "c"})
+	if err := storage.Close(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	loaded, err := New[*ExampleStruct](file, true)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if v, ok := loaded.Get(id); !ok || v.Field1 != "c" {
+		t.Fatalf("expected entry added before Close, got %v", v)
+	}
+	if loaded.GetMaxId() != storage.GetMaxId() {
+		t.Fatalf("expected max id %d, got %d", storage.GetMaxId(), loaded.GetMaxId())
+	}
+}
+
+func TestCloseAfterBackup(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "backup_storage")
+
+	storage, err := New[*ExampleStruct](file, false)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	storage.EnableAutoSave(time.Hour, 1, nil)
+	id := storage.Add(&ExampleStruct{Field1: "a"})
+
+	if err := storage.SaveAs(file + ".backup0"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if pending := storage.AutoSaveStats().Pending; pending == 0 {
+		t.Fatalf("expected changes pending after backup")
+	}
+	if err := storage.Close(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	loaded, err := New[*ExampleStruct](file, true)
+	if err != nil {
+		t.Fatalf("expected storage file after Close, got %v", err)
+	}
+	if _, ok := loaded.Get(id); !ok {
+		t.Fatalf("expected entry %d in storage file", id)
+	}
+}
@@ -4,9 +4,10 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"log/slog"
 	"time"
 
-	"github.com/MasterDimmy/zipologger"
 	"github.com/goupdate/compactmap/structmap"
 	"github.com/valyala/fasthttp"
 )
@@ -16,9 +17,9 @@ var (
 )
 
 type Client[V any] struct {
-	baseURL   string
-	client    *fasthttp.Client
-	error_log *zipologger.Logger
+	baseURL string
+	client  *fasthttp.Client
+	log     *slog.Logger
 }
 
 func New[V any](baseURL string) *Client[V] {
@@ -28,11 +29,16 @@ func New[V any](baseURL string) *Client[V] {
 			ReadTimeout:  Timeout,
 			WriteTimeout: Timeout,
 		},
+		log: slog.New(slog.NewTextHandler(io.Discard, nil)),
 	}
 }
 
-func (c *Client[V]) SetErrorLog(log *zipologger.Logger) *Client[V] {
-	c.error_log = log
+// SetLogger sets logger for request errors, nothing is logged by default
+func (c *Client[V]) SetLogger(log *slog.Logger) *Client[V] {
+	if log == nil {
+		log = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	c.log = log
 	return c
 }
 
@@ -52,9 +58,7 @@ func (c *Client[V]) post(endpoint string, requestBody interface{}) ([]byte, erro
 	if requestBody != nil {
 		body, err = json.Marshal(requestBody)
 		if err != nil {
-			if c.error_log != nil {
-				c.error_log.Printf("post error: [%s] : [%s]", string(body), err.Error())
-			}
+			c.log.Error("client: post error", "body", string(body), "err", err)
 			return nil, err
 		}
 	}
@@ -71,16 +75,12 @@ func (c *Client[V]) post(endpoint string, requestBody interface{}) ([]byte, erro
 
 	err = c.client.DoTimeout(req, resp, Timeout)
 	if err != nil {
-		if c.error_log != nil {
-			c.error_log.Printf("timeout: [%s]", err.Error())
-		}
+		c.log.Error("client: timeout", "err", err)
 		return nil, err
 	}
 
 	if resp.StatusCode() != fasthttp.StatusOK {
-		if c.error_log != nil {
-			c.error_log.Printf("incorrect status [%d] : [%s]", resp.StatusCode(), string(resp.Body()))
-		}
+		c.log.Error("client: incorrect status", "status", resp.StatusCode(), "body", string(resp.Body()))
 		return nil, fmt.Errorf(string(resp.Body()))
 	}
 
@@ -98,16 +98,12 @@ func (c *Client[V]) get(endpoint string) ([]byte, error) {
 
 	err := c.client.DoTimeout(req, resp, Timeout)
 	if err != nil {
-		if c.error_log != nil {
-			c.error_log.Printf("get error: [%s]", err.Error())
-		}
+		c.log.Error("client: get error", "err", err)
 		return nil, err
 	}
 
 	if resp.StatusCode() != fasthttp.StatusOK {
-		if c.error_log != nil {
-			c.error_log.Printf("incorrect status [%d] : [%s]", resp.StatusCode(), string(resp.Body()))
-		}
+		c.log.Error("client: incorrect status", "status", resp.StatusCode(), "body", string(resp.Body()))
 		return nil, fmt.Errorf(string(resp.Body()))
 	}
 
@@ -131,9 +127,7 @@ func (c *Client[V]) Add(item *V) (int64, error) {
 
 	err = json.Unmarshal(response, &result)
 	if err != nil {
-		if c.error_log != nil {
-			c.error_log.Printf("add error: [%s] : [%s]", string(response), err.Error())
-		}
+		c.log.Error("client: add error", "response", string(response), "err", err)
 	}
 	return result.Id, err
 }
@@ -151,9 +145,7 @@ func (c *Client[V]) Get(id int64) (*V, error) {
 	var item V
 	err = json.Unmarshal(response, &item)
 	if err != nil {
-		if c.error_log != nil {
-			c.error_log.Printf("get error: [%s] : [%s]", string(response), err.Error())
-		}
+		c.log.Error("client: get error", "response", string(response), "err", err)
 	}
 	return &item, err
 }
@@ -186,9 +178,7 @@ func (c *Client[V]) Update(condition string, where []structmap.FindCondition, fi
 	}
 	err = json.Unmarshal(response, &result)
 	if err != nil {
-		if c.error_log != nil {
-			c.error_log.Printf("update error: [%s] : [%s]", string(response), err.Error())
-		}
+		c.log.Error("client: update error", "response", string(response), "err", err)
 	}
 	return result.Updated, err
 }
@@ -220,9 +210,7 @@ func (c *Client[V]) UpdateCount(condition string, where []structmap.FindConditio
 	}
 	err = json.Unmarshal(response, &result)
 	if err != nil {
-		if c.error_log != nil {
-			c.error_log.Printf("update count error: [%s] : [%s]", string(response), err.Error())
-		}
+		c.log.Error("client: update count error", "response", string(response), "err", err)
 	}
 	return result.Updated, err
 }
@@ -255,9 +243,7 @@ func (c *Client[V]) UpdateCountRandom(condition string, where []structmap.FindCo
 	}
 	err = json.Unmarshal(response, &result)
 	if err != nil {
-		if c.error_log != nil {
-			c.error_log.Printf("update count random error: [%s] : [%s]", string(response), err.Error())
-		}
+		c.log.Error("client: update count random error", "response", string(response), "err", err)
 	}
 	return result.Updated, err
 }
@@ -275,9 +261,7 @@ func (c *Client[V]) SetField(id int64, field string, value interface{}) error {
 
 	_, err := c.post("/api/setfield", req)
 	if err != nil {
-		if c.error_log != nil {
-			c.error_log.Printf("setfield error: [%s]", err.Error())
-		}
+		c.log.Error("client: setfield error", "err", err)
 	}
 	return err
 }
@@ -293,9 +277,7 @@ func (c *Client[V]) SetFields(id int64, fields map[string]interface{}) error {
 
 	_, err := c.post("/api/setfields", req)
 	if err != nil {
-		if c.error_log != nil {
-			c.error_log.Printf("setfields error: [%s]", err.Error())
-		}
+		c.log.Error("client: setfields error", "err", err)
 	}
 	return err
 }
@@ -319,9 +301,7 @@ func (c *Client[V]) Find(condition string, where []structmap.FindCondition) ([]*
 	var results []*V
 	err = json.Unmarshal(response, &results)
 	if err != nil {
-		if c.error_log != nil {
-			c.error_log.Printf("find error: [%s] : [%s]", string(response), err.Error())
-		}
+		c.log.Error("client: find error", "response", string(response), "err", err)
 	}
 	return results, err
 }
@@ -335,9 +315,7 @@ func (c *Client[V]) All() ([]*V, error) {
 	var results []*V
 	err = json.Unmarshal(response, &results)
 	if err != nil {
-		if c.error_log != nil {
-			c.error_log.Printf("all error: [%s] : [%s]", string(response), err.Error())
-		}
+		c.log.Error("client: all error", "response", string(response), "err", err)
 	}
 	return results, err
 }
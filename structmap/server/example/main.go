@@ -1,8 +1,10 @@
 package main
 
 import (
+	"log/slog"
+	"os"
+
 	"github.com/MasterDimmy/go-ctrlc"
-	"github.com/MasterDimmy/zipologger"
 	"github.com/goupdate/compactmap/structmap/server"
 )
 
@@ -14,44 +16,40 @@ type some1 struct {
 }
 
 func main() {
-	defer zipologger.Wait()
-	defer zipologger.HandlePanic()
-
 	var ctrl ctrlc.CtrlC
-	log := zipologger.NewLogger("./logs/server.log", 1, 1, 1, false)
+	log := slog.New(slog.NewTextHandler(os.Stderr, nil))
 
 	srv, err := server.New[some1]("storage1")
 	if err != nil {
-		log.Print(err.Error())
+		log.Error(err.Error())
 		return
 	}
 
 	if srv == nil {
-		log.Print("no srv!")
+		log.Error("no srv!")
 		return
 	}
 
+	srv.SetLogger(log)
 	srv.SetLoggingLevel(2)
 
 	defer srv.Shutdown()
 	defer ctrl.DeferThisToWaitCtrlC()
 
 	go func() {
-		defer zipologger.HandlePanic()
-
 		srv := srv.GetFasthttpServer()
 		if srv != nil {
 			err := srv.ListenAndServe(":80")
 			if err != nil {
-				log.Print("server error: " + err.Error())
+				log.Error("server error", "err", err)
 			}
 		} else {
-			log.Print("no srv server!")
+			log.Error("no srv server!")
 		}
 		ctrl.ForceStopProgram()
 	}()
 
 	ctrl.InterceptKill(true, func() {
-		log.Println("software was stopped via Ctrl+C")
+		log.Info("software was stopped via Ctrl+C")
 	})
 }
@@ -3,13 +3,14 @@ package server
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"log/slog"
 	"reflect"
+	"runtime/debug"
 	"strconv"
 	"sync"
 	"time"
 
-	"github.com/MasterDimmy/zipologger"
-
 	"github.com/goupdate/compactmap/structmap"
 	"github.com/valyala/fasthttp"
 )
@@ -24,7 +25,7 @@ type Server[V any] struct {
 	backupsTicker *time.Ticker
 
 	logsLevel int //0 = OFF, 1=CALLS, 2=CALLS+DATA
-	log       *zipologger.Logger
+	log       *slog.Logger
 }
 
 func (s *Server[V]) Shutdown() {
@@ -32,7 +33,7 @@ func (s *Server[V]) Shutdown() {
 		s.srv.Shutdown()
 	}
 	if s.storage != nil {
-		s.storage.Save()
+		s.storage.Close()
 	}
 }
 
@@ -44,16 +45,14 @@ func New[V any](storageName string) (*Server[V], error) {
 		return nil, fmt.Errorf("Failed to initialize storage: %v", err)
 	}
 
-	log := zipologger.NewLogger("./logs/server_api.log", 5, 5, 5, false)
-
 	server := &Server[V]{
 		storage:     storage,
-		log:         log,
+		log:         slog.New(slog.NewTextHandler(io.Discard, nil)),
 		storageName: storageName,
 	}
 
 	router := fasthttp.RequestHandler(func(ctx *fasthttp.RequestCtx) {
-		defer zipologger.HandlePanic()
+		defer server.handlePanic(ctx)
 
 		switch string(ctx.Path()) {
 		case "/api/clear":
@@ -85,10 +84,25 @@ func New[V any](storageName string) (*Server[V], error) {
 	return server, nil
 }
 
-func (s *Server[V]) SetLogger(log *zipologger.Logger) {
+// SetLogger sets logger for requests, backups and storage files, nothing is logged by default
+func (s *Server[V]) SetLogger(log *slog.Logger) {
+	s.storage.SetLogger(log)
+	if log == nil {
+		log = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
 	s.log = log
 }
 
+// handlePanic logs panic of a handler and responds with an error if ctx is not nil
+func (s *Server[V]) handlePanic(ctx *fasthttp.RequestCtx) {
+	if r := recover(); r != nil {
+		s.log.Error("server: panic", "panic", r, "stack", string(debug.Stack()))
+		if ctx != nil {
+			ctx.Error("Internal server error", fasthttp.StatusInternalServerError)
+		}
+	}
+}
+
 func (s *Server[V]) GetFasthttpServer() *fasthttp.Server {
 	return s.srv
 }
@@ -106,15 +120,15 @@ func (s *Server[V]) EnableBackupsEvery(interval time.Duration, storeBackups int)
 	}
 	s.backupsTicker = time.NewTicker(interval)
 	go func() {
-		defer zipologger.HandlePanic()
+		defer s.handlePanic(nil)
 
 		num := 0
 		for range s.backupsTicker.C {
 			fname := s.storageName + ".backup" + fmt.Sprintf("%d", num)
-			s.log.Print("Autobackup to " + fname)
+			s.log.Info("server: autobackup", "file", fname)
 			err := s.storage.SaveAs(fname)
 			if err != nil {
-				s.log.Printf("ERROR: %v", err.Error())
+				s.log.Error("server: autobackup failed", "file", fname, "err", err)
 			}
 			num++
 			num = num % storeBackups
@@ -146,18 +160,17 @@ func (s *Server[V]) SetLoggingLevel(l int) {
 }
 
 func (s *Server[V]) logAction(ctx *fasthttp.RequestCtx, response ...interface{}) {
-	if s.log == nil {
-		return
-	}
 	switch s.logsLevel {
 	case 0:
 		return
 	case 1:
-		s.log.Printf("%s : %s", ctx.RemoteIP().String(), string(ctx.Request.URI().Path()))
+		s.log.Info("server: call", "ip", ctx.RemoteIP().String(), "path", string(ctx.Request.URI().Path()))
 	case 2:
-		s.log.Printf("%s : %s [%s%s%s]", ctx.RemoteIP().String(), string(ctx.Request.URI().Path()), ctx.QueryArgs().String(), ctx.PostArgs().String())
+		s.log.Info("server: call", "ip", ctx.RemoteIP().String(), "path", string(ctx.Request.URI().Path()),
+			"query", ctx.QueryArgs().String(), "post", ctx.PostArgs().String())
 	case 3:
-		s.log.Printf("%s : %s [%s%s%s]", ctx.RemoteIP().String(), string(ctx.Request.URI().Path()), ctx.QueryArgs().String(), ctx.PostArgs().String())
+		s.log.Info("server: call", "ip", ctx.RemoteIP().String(), "path", string(ctx.Request.URI().Path()),
+			"query", ctx.QueryArgs().String(), "post", ctx.PostArgs().String())
 		if len(response) > 0 {
 			ret := ""
 			for _, r := range response {
@@ -176,7 +189,7 @@ func (s *Server[V]) logAction(ctx *fasthttp.RequestCtx, response ...interface{})
 					}
 				}
 			}
-			s.log.Printf("%s", ret)
+			s.log.Info("server: response", "path", string(ctx.Request.URI().Path()), "response", ret)
 		}
 	}
 }
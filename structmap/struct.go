@@ -2,6 +2,7 @@ package structmap
 
 import (
 	"fmt"
+	"log/slog"
 	"math/rand"
 	"reflect"
 	"strings"
@@ -23,12 +24,24 @@ type StructMap[V any] struct {
 	info *compactmap.CompactMap[int64, int64] // Store maxId
 
 	storageFile string
+	unsaved     int64 // changes saved only into other files by SaveAs, see pending
 
 	maxId int64 // Max stored id, incremented after Add
+
+	autosave *autoSaver // see EnableAutoSave
 }
 
 // V - should be pointer to struct
 func New[V any](storageFile string, failIfNotLoaded bool) (*StructMap[V], error) {
+	return newStructMap[V](storageFile, failIfNotLoaded, nil)
+}
+
+// NewEncrypted is New with storage files encrypted by keys, see compactmap.SetEncryption
+func NewEncrypted[V any](storageFile string, failIfNotLoaded bool, keys compactmap.KeyProvider) (*StructMap[V], error) {
+	return newStructMap[V](storageFile, failIfNotLoaded, keys)
+}
+
+func newStructMap[V any](storageFile string, failIfNotLoaded bool, keys compactmap.KeyProvider) (*StructMap[V], error) {
 	var zero V
 	valType := reflect.TypeOf(&zero).Elem()
 
@@ -53,12 +66,18 @@ func New[V any](storageFile string, failIfNotLoaded bool) (*StructMap[V], error)
 	}
 
 	cm := compactmap.NewCompactMap[int64, V]()
+	if keys != nil {
+		cm.SetEncryption(keys)
+	}
 	err := cm.Init(storageFile)
 	if err != nil && failIfNotLoaded {
 		return nil, err
 	}
 
 	info := compactmap.NewCompactMap[int64, int64]()
+	if keys != nil {
+		info.SetEncryption(keys)
+	}
 	err = info.Init(storageFile + "i")
 	if err != nil && failIfNotLoaded {
 		return nil, err
@@ -89,10 +108,17 @@ func (p *StructMap[V]) SaveAs(name string) error {
 	defer p.Unlock()
 
 	p.info.AddOrSet(1, p.maxId)
+	before := p.cm.Mutations()
 	err := p.cm.Save(name)
 	if err != nil {
 		return err
 	}
+	if name == p.storageFile {
+		p.unsaved = 0
+	} else {
+		// a backup may reset the counter, its changes are still pending for the storage file
+		p.unsaved += max(before-p.cm.Mutations(), 0)
+	}
 	err2 := p.info.Save(name + "i")
 	if err2 != nil {
 		return err2
@@ -100,6 +126,12 @@ func (p *StructMap[V]) SaveAs(name string) error {
 	return nil
 }
 
+// SetLogger sets logger for saves and loads of the storage files, nil disables logging
+func (p *StructMap[V]) SetLogger(logger *slog.Logger) {
+	p.cm.SetLogger(logger)
+	p.info.SetLogger(logger)
+}
+
 // SetField sets a specific field to a value for a struct by ID
 func (p *StructMap[V]) SetField(id int64, field string, value interface{}) bool {
 	return p.SetFields(id, map[string]interface{}{field: value})
@@ -182,7 +214,7 @@ condition logic can be:
 OR - where1 || where2 || where3 ...
 AND - where1 && where2 && where3
 
-elCount - count of first elements to update, 0 if no limit
+elCount - count of first elements to update, 0 or less if no limit
 
 Returns: slice of Ids of updated elements
 */
@@ -191,42 +223,53 @@ func (p *StructMap[V]) UpdateCount(condition string, where []FindCondition, fiel
 	defer p.Unlock()
 
 	var ids []int64
-	count := 0
-	p.FindFn(condition, where, func(id int64, v V) bool {
-		ids = append(ids, id)
-		if !random {
-			count++
-			if elCount > 0 && count == elCount {
-				return false
-			}
-		}
-		return true
-	})
+	if random {
+		ids = p.randomIds(condition, where, elCount)
+	} else {
+		p.FindFn(condition, where, func(id int64, v V) bool {
+			ids = append(ids, id)
+			return elCount <= 0 || len(ids) < elCount
+		})
+	}
 
 	// If no ids were found, return an empty slice
 	if len(ids) == 0 {
 		return nil
 	}
 
-	upd := ids
+	for _, id := range ids {
+		p.setFields(id, fields)
+	}
+	return ids
+}
 
-	if random {
-		//how many to update
-		if elCount == 0 || elCount > len(ids) {
-			elCount = len(ids)
+// randomIds returns up to n random ids of structs that match the conditions, all of them if n is 0.
+// Without conditions ids are sampled by positions, otherwise by reservoir sampling of matches.
+func (p *StructMap[V]) randomIds(condition string, where []FindCondition, n int) []int64 {
+	if len(where) == 0 && strings.ToUpper(condition) != "OR" {
+		if n <= 0 {
+			n = p.cm.Count()
 		}
-
-		upd = make([]int64, elCount)
-
-		// Shuffle the ids and take the first elCount elements
-		rand.Shuffle(len(ids), func(i, j int) { ids[i], ids[j] = ids[j], ids[i] })
-		copy(upd, ids[:elCount])
+		sample := p.cm.Sample(n)
+		ids := make([]int64, len(sample))
+		for i, e := range sample {
+			ids[i] = e.Key
+		}
+		return ids
 	}
 
-	for _, id := range upd {
-		p.setFields(id, fields)
-	}
-	return upd
+	var ids []int64
+	seen := 0
+	p.FindFn(condition, where, func(id int64, v V) bool {
+		if n <= 0 || len(ids) < n {
+			ids = append(ids, id)
+		} else if j := rand.Intn(seen + 1); j < n {
+			ids[j] = id
+		}
+		seen++
+		return true
+	})
+	return ids
 }
 
 // GetAll retrieves all structs from the map
@@ -1,10 +1,13 @@
 package structmap
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"reflect"
 	"testing"
+
+	"github.com/goupdate/compactmap"
 )
 
 type CustomString string
@@ -365,6 +368,23 @@ func TestUpdate(t *testing.T) {
 	}
 }
 
+func TestUpdateCountNoLimit(t *testing.T) {
+	storage, _ := New[*ExampleStruct]("test_storage", false)
+	storage.Clear()
+
+	for i := 0; i < 5; i++ {
+		storage.Add(&ExampleStruct{Field1: "a", Field2: i})
+	}
+
+	where := []FindCondition{{Field: "Field1", Value: "a", Op: "equal"}}
+	for _, random := range []bool{false, true} {
+		ids := storage.UpdateCount("AND", where, map[string]interface{}{"Field2": 7}, -1, random)
+		if len(ids) != 5 {
+			t.Fatalf("expected negative elCount to update all 5 items (random %v), got %d", random, len(ids))
+		}
+	}
+}
+
 func TestUpdateCountRandom(t *testing.T) {
 	storage, _ := New[*ExampleStruct]("test_storage", false)
 	storage.Clear()
@@ -404,6 +424,42 @@ func TestUpdateCountRandom(t *testing.T) {
 
 	t.Fatalf("expected to update different elemtemts, but got same")
 }
+
+func TestUpdateCountRandomSample(t *testing.T) {
+	storage, _ := New[*ExampleStruct]("test_storage", false)
+	storage.Clear()
+
+	for i := range 3000 {
+		storage.Add(&ExampleStruct{Field1: "value" + fmt.Sprintf("%d", i), Field2: i % 2})
+	}
+
+	// without conditions ids are sampled from the whole map
+	ids := storage.UpdateCount("", nil, map[string]interface{}{"Field1": "sampled"}, 10, true)
+	if len(ids) != 10 {
+		t.Fatalf("expected to update 10 items, got %d", len(ids))
+	}
+	seen := map[int64]bool{}
+	for _, id := range ids {
+		v, ok := storage.Get(id)
+		if !ok || v.Field1 != "sampled" || seen[id] {
+			t.Fatalf("expected distinct updated item %d", id)
+		}
+		seen[id] = true
+	}
+
+	// with conditions only matching items are chosen
+	ids = storage.UpdateCount("AND", []FindCondition{{Field: "Field2", Value: 1}}, map[string]interface{}{"Field1": "odd"}, 10, true)
+	if len(ids) != 10 {
+		t.Fatalf("expected to update 10 items, got %d", len(ids))
+	}
+	for _, id := range ids {
+		v, _ := storage.Get(id)
+		if v.Field2 != 1 || v.Field1 != "odd" {
+			t.Fatalf("expected matching item updated, got %+v", v)
+		}
+	}
+}
+
 func TestSave(t *testing.T) {
 	storage, _ := New[*ExampleStruct]("test_storage", false)
 
@@ -883,3 +939,33 @@ func TestSetStringIntoBool(t *testing.T) {
 		}
 	}
 }
+
+func TestNewEncrypted(t *testing.T) {
+	file := t.TempDir() + "/encrypted_storage"
+	keys := compactmap.StaticKey([]byte("0123456789abcdef0123456789abcdef"))
+
+	storage, err := NewEncrypted[*ExampleStruct](file, false, keys)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	id := storage.Add(&ExampleStruct{Field1: "secret"})
+	if err := storage.Save(); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+
+	if _, err := New[*ExampleStruct](file, true); !errors.Is(err, compactmap.ErrEncrypted) {
+		t.Fatalf("expected ErrEncrypted, got %v", err)
+	}
+
+	loaded, err := NewEncrypted[*ExampleStruct](file, true, keys)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	item, ok := loaded.Get(id)
+	if !ok || item.Field1 != "secret" {
+		t.Fatalf("expected stored item, got %v", item)
+	}
+	if loaded.GetMaxId() != storage.GetMaxId() {
+		t.Fatalf("expected max id %d, got %d", storage.GetMaxId(), loaded.GetMaxId())
+	}
+}
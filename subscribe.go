@@ -0,0 +1,181 @@
+package compactmap
+
+import (
+	"golang.org/x/exp/constraints"
+)
+
+type EventType int
+
+const (
+	EventSet    EventType = iota + 1 // key added or overwritten
+	EventDelete                      // key deleted, evicted or expired
+	EventClear                       // map cleared, Key, Old and New are zero
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventSet:
+		return "set"
+	case EventDelete:
+		return "delete"
+	case EventClear:
+		return "clear"
+	}
+	return "unknown"
+}
+
+// Event describes a change of the map
+type Event[K constraints.Ordered, V any] struct {
+	Type   EventType
+	Key    K
+	Old    V    // previous value for EventSet and EventDelete
+	HadOld bool // Old is set: key existed before EventSet
+	New    V    // new value for EventSet
+}
+
+// OverflowPolicy tells what to do when a buffered subscriber does not keep up
+type OverflowPolicy int
+
+const (
+	OverflowBlock      OverflowPolicy = iota // writer waits for free space after releasing the map lock
+	OverflowDropNewest                       // new event is dropped
+	OverflowDropOldest                       // the oldest queued event is dropped
+)
+
+type subscriber[K constraints.Ordered, V any] struct {
+	id       int
+	fn       func(Event[K, V])
+	ch       chan Event[K, V] // nil for synchronous delivery
+	overflow OverflowPolicy
+}
+
+type pendingEvent[K constraints.Ordered, V any] struct {
+	sub *subscriber[K, V]
+	e   Event[K, V]
+}
+
+// Subscribe registers fn called synchronously under the map write lock for every change,
+// so events come in order of changes. fn should not call methods of the map.
+// Returns id for Unsubscribe.
+func (m *CompactMap[K, V]) Subscribe(fn func(Event[K, V])) int {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.subscribe(&subscriber[K, V]{fn: fn})
+}
+
+// SubscribeAsync registers fn called from a separate goroutine.
+// Events are queued in a channel of bufferSize, overflow tells what to do when it is full.
+// With OverflowBlock the writer waits for free space after releasing the map lock,
+// so fn may read the map but should not change it: a change waits for earlier events to be queued.
+// Returns id for Unsubscribe.
+func (m *CompactMap[K, V]) SubscribeAsync(fn func(Event[K, V]), bufferSize int, overflow OverflowPolicy) int {
+	m.Lock()
+	defer m.Unlock()
+
+	if bufferSize < 1 {
+		bufferSize = 1
+	}
+	sub := &subscriber[K, V]{
+		fn:       fn,
+		ch:       make(chan Event[K, V], bufferSize),
+		overflow: overflow,
+	}
+	go func() {
+		for e := range sub.ch {
+			sub.fn(e)
+		}
+	}()
+	return m.subscribe(sub)
+}
+
+func (m *CompactMap[K, V]) subscribe(sub *subscriber[K, V]) int {
+	m.subSeq++
+	sub.id = m.subSeq
+	m.subs = append(m.subs, sub)
+	return sub.id
+}
+
+// Unsubscribe removes subscriber, already queued async events are still delivered
+func (m *CompactMap[K, V]) Unsubscribe(id int) {
+	m.Lock()
+	var sub *subscriber[K, V]
+	for i, s := range m.subs {
+		if s.id == id {
+			sub = s
+			m.subs = append(m.subs[:i:i], m.subs[i+1:]...)
+			break
+		}
+	}
+	delivered := m.delivered
+	m.Unlock()
+
+	if sub == nil || sub.ch == nil {
+		return
+	}
+	// events queued by earlier writers are sent before the channel is closed
+	if delivered != nil {
+		<-delivered
+	}
+	close(sub.ch)
+}
+
+// notify is called under the write lock
+func (m *CompactMap[K, V]) notify(e Event[K, V]) {
+	for _, sub := range m.subs {
+		if sub.ch == nil {
+			sub.fn(e)
+			continue
+		}
+
+		switch sub.overflow {
+		case OverflowDropNewest:
+			select {
+			case sub.ch <- e:
+			default:
+			}
+		case OverflowDropOldest:
+			for sent := false; !sent; {
+				select {
+				case sub.ch <- e:
+					sent = true
+				default:
+					select {
+					case <-sub.ch:
+					default:
+					}
+				}
+			}
+		default:
+			m.pending = append(m.pending, pendingEvent[K, V]{sub: sub, e: e})
+		}
+	}
+}
+
+// unlock releases the write lock and sends events queued for OverflowBlock subscribers
+func (m *CompactMap[K, V]) unlock() {
+	m.unlockWith(m.Unlock)
+}
+
+// unlockWith releases the write lock by unlock, then sends pending events.
+// Batches of different writers are sent in order of changes, each waits for the previous one.
+func (m *CompactMap[K, V]) unlockWith(unlock func()) {
+	pending := m.pending
+	if len(pending) == 0 {
+		unlock()
+		return
+	}
+	m.pending = nil
+	prev := m.delivered
+	done := make(chan struct{})
+	m.delivered = done
+	unlock()
+
+	if prev != nil {
+		<-prev
+	}
+	for _, p := range pending {
+		p.sub.ch <- p.e
+	}
+	close(done)
+}
@@ -0,0 +1,167 @@
+package compactmap
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscribe(t *testing.T) {
+	cm := NewCompactMap[int, int]()
+
+	var events []Event[int, int]
+	id := cm.Subscribe(func(e Event[int, int]) {
+		events = append(events, e)
+	})
+
+	cm.AddOrSet(1, 100)
+	cm.AddOrSet(1, 101)
+	cm.Delete(1)
+	cm.Delete(2) // nothing deleted, no event
+	cm.AddOrSet(3, 300)
+	cm.Clear()
+
+	assert.Equal(t, []Event[int, int]{
+		{Type: EventSet, Key: 1, New: 100},
+		{Type: EventSet, Key: 1, Old: 100, HadOld: true, New: 101},
+		{Type: EventDelete, Key: 1, Old: 101, HadOld: true},
+		{Type: EventSet, Key: 3, New: 300},
+		{Type: EventClear},
+	}, events)
+
+	cm.Unsubscribe(id)
+	cm.AddOrSet(4, 400)
+	assert.Equal(t, 5, len(events), "No events after Unsubscribe")
+}
+
+func TestSubscribeEvictionAndExpiry(t *testing.T) {
+	cm := NewCompactMap[int, int]()
+	cm.SetBounds(Bounds[int, int]{MaxEntries: 1})
+
+	var deleted []int
+	cm.Subscribe(func(e Event[int, int]) {
+		if e.Type == EventDelete {
+			deleted = append(deleted, e.Key)
+		}
+	})
+
+	cm.AddOrSet(1, 100)
+	cm.AddOrSet(2, 200)
+	assert.Equal(t, []int{1}, deleted, "Eviction should be reported as delete")
+
+	cm.AddOrSetWithTTL(2, 201, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	cm.DeleteExpired()
+	assert.Equal(t, []int{1, 2}, deleted, "Expiration should be reported as delete")
+}
+
+func TestSubscribeAsync(t *testing.T) {
+	cm := NewCompactMap[int, int]()
+
+	var mu sync.Mutex
+	var keys []int
+	id := cm.SubscribeAsync(func(e Event[int, int]) {
+		mu.Lock()
+		keys = append(keys, e.Key)
+		mu.Unlock()
+	}, 16, OverflowBlock)
+
+	for i := 0; i < 100; i++ {
+		cm.AddOrSet(i, i)
+	}
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(keys) == 100
+	}, time.Second, time.Millisecond)
+
+	mu.Lock()
+	for i, k := range keys {
+		assert.Equal(t, i, k, "Events should be delivered in order")
+	}
+	mu.Unlock()
+	cm.Unsubscribe(id)
+}
+
+func TestSubscribeOverflow(t *testing.T) {
+	cm := NewCompactMap[int, int]()
+
+	release := make(chan struct{})
+	got := map[OverflowPolicy]*[]int{OverflowDropNewest: {}, OverflowDropOldest: {}}
+	var mu sync.Mutex
+
+	for policy, keys := range got {
+		keys := keys
+		cm.SubscribeAsync(func(e Event[int, int]) {
+			<-release
+			mu.Lock()
+			*keys = append(*keys, e.Key)
+			mu.Unlock()
+		}, 2, policy)
+	}
+
+	// first event is taken by the subscriber goroutine, 2 more fit into the buffer
+	cm.AddOrSet(0, 0)
+	time.Sleep(10 * time.Millisecond)
+	for i := 1; i < 10; i++ {
+		cm.AddOrSet(i, i)
+	}
+	close(release)
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(*got[OverflowDropNewest]) == 3 && len(*got[OverflowDropOldest]) == 3
+	}, time.Second, time.Millisecond)
+
+	mu.Lock()
+	assert.Equal(t, []int{0, 1, 2}, *got[OverflowDropNewest])
+	assert.Equal(t, []int{0, 8, 9}, *got[OverflowDropOldest])
+	mu.Unlock()
+}
+
+func TestSubscribeBlockReadsMap(t *testing.T) {
+	cm := NewCompactMap[int, int]()
+
+	var mu sync.Mutex
+	var values []int
+	id := cm.SubscribeAsync(func(e Event[int, int]) {
+		// reading the map must not deadlock with the writer waiting for free space
+		v, _ := cm.Get(e.Key)
+		mu.Lock()
+		values = append(values, v)
+		mu.Unlock()
+	}, 1, OverflowBlock)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 250; i++ {
+				cm.AddOrSet(w*250+i, 1)
+			}
+		}(w)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Writers blocked")
+	}
+
+	cm.Unsubscribe(id)
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(values) == 1000
+	}, time.Second, time.Millisecond)
+}
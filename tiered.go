@@ -0,0 +1,529 @@
+package compactmap
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/exp/constraints"
+)
+
+/*
+	TieredMap keeps recent changes in an in-memory CompactMap (memtable) and spills it
+	into immutable sorted segment files when it reaches the memtable limit.
+	Reads look into the memtable and then segments from the newest to the oldest,
+	reading one block of a segment through the block cache.
+	Deleted keys are stored as tombstones until compaction merges all segments into one.
+
+	The memtable is not written to disk until it is full, Flush or Close are called,
+	so changes made after the last flush are lost if the process crashes.
+
+	Writes look the key up like Get to keep Count exact: a key missing from the memtable
+	costs a block read (through the cache) in every segment whose key range covers it.
+*/
+
+const (
+	defaultMemEntries  = 100 * maxSliceSize
+	defaultCacheBlocks = 1024
+	defaultMaxSegments = 4
+)
+
+type TieredMap[K constraints.Ordered, V any] struct {
+	sync.RWMutex
+
+	dir        string
+	mem        *CompactMap[K, tieredValue[V]] // accessed under the lock of TieredMap
+	memEntries int
+	segments   []*segment[K, V] // from the oldest to the newest
+	seq        uint64           // sequence number of the last flush
+	count      int
+
+	cache       *blockCache[K, V]
+	maxSegments int
+
+	compactMu  sync.Mutex // one compaction at a time
+	compacting bool       // background compaction is running
+	wg         sync.WaitGroup
+
+	errMu  sync.Mutex
+	err    error // the first i/o error, see Err
+	logger *slog.Logger
+}
+
+// OpenTieredMap opens or creates a map stored in dir,
+// memEntries changes are kept in memory before they are written to a new segment, <= 0 means default.
+// Segments left by an interrupted compaction are removed.
+func OpenTieredMap[K constraints.Ordered, V any](dir string, memEntries int) (*TieredMap[K, V], error) {
+	if memEntries <= 0 {
+		memEntries = defaultMemEntries
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	type segName struct {
+		name     string
+		from, to uint64
+	}
+	var names []segName
+	for _, f := range files {
+		if strings.Contains(f.Name(), ".seg.") && strings.HasSuffix(f.Name(), ".tmp") {
+			os.Remove(filepath.Join(dir, f.Name()))
+			continue
+		}
+		if from, to, ok := parseSegmentName(f.Name()); ok {
+			names = append(names, segName{f.Name(), from, to})
+		}
+	}
+
+	// a segment covered by the range of another one was merged into it
+	var live []segName
+	for _, a := range names {
+		covered := false
+		for _, b := range names {
+			if a != b && b.from <= a.from && a.to <= b.to {
+				covered = true
+				break
+			}
+		}
+		if covered {
+			if err := os.Remove(filepath.Join(dir, a.name)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		live = append(live, a)
+	}
+	slices.SortFunc(live, func(a, b segName) int {
+		return cmp.Compare(a.to, b.to)
+	})
+
+	m := &TieredMap[K, V]{
+		dir:         dir,
+		mem:         NewCompactMap[K, tieredValue[V]](),
+		memEntries:  memEntries,
+		cache:       newBlockCache[K, V](defaultCacheBlocks),
+		maxSegments: defaultMaxSegments,
+	}
+	for _, name := range live {
+		s, err := openSegment[K, V](filepath.Join(dir, name.name))
+		if err != nil {
+			m.closeSegments()
+			return nil, err
+		}
+		m.segments = append(m.segments, s)
+		m.seq = s.to
+		m.count = s.index.Count
+	}
+	return m, nil
+}
+
+// SetCacheBlocks sets how many decoded segment blocks are cached, every block has up to 1000 entries
+func (m *TieredMap[K, V]) SetCacheBlocks(n int) {
+	m.cache.setCapacity(n)
+}
+
+// SetMaxSegments sets how many segments trigger background compaction after a flush
+func (m *TieredMap[K, V]) SetMaxSegments(n int) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.maxSegments = max(n, 1)
+}
+
+// SetLogger sets logger for flushes and compactions, nil disables logging
+func (m *TieredMap[K, V]) SetLogger(logger *slog.Logger) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.logger = logger
+}
+
+func (m *TieredMap[K, V]) log() *slog.Logger {
+	if m.logger == nil {
+		return discardLogger
+	}
+	return m.logger
+}
+
+// Err returns the first error of reading or writing segments.
+// Get and Iterate cannot return errors, they skip unreadable blocks.
+func (m *TieredMap[K, V]) Err() error {
+	m.errMu.Lock()
+	defer m.errMu.Unlock()
+
+	return m.err
+}
+
+func (m *TieredMap[K, V]) setErr(err error) {
+	m.errMu.Lock()
+	if m.err == nil {
+		m.err = err
+	}
+	m.errMu.Unlock()
+}
+
+// lookup returns the newest version of key, the map should be locked
+func (m *TieredMap[K, V]) lookup(key K) (tieredValue[V], bool) {
+	if v, ok := m.mem.get(key); ok {
+		return v, true
+	}
+	for i := len(m.segments) - 1; i >= 0; i-- {
+		v, ok, err := m.segments[i].get(key, m.cache)
+		if err != nil {
+			m.setErr(err)
+			m.log().Error("compactmap: segment read failed", "err", err)
+			continue
+		}
+		if ok {
+			return v, true
+		}
+	}
+	return tieredValue[V]{}, false
+}
+
+func (m *TieredMap[K, V]) Get(key K) (V, bool) {
+	m.RLock()
+	defer m.RUnlock()
+
+	v, ok := m.lookup(key)
+	if !ok || v.Deleted {
+		var zero V
+		return zero, false
+	}
+	return v.Value, true
+}
+
+// alias map-compatible
+func (m *TieredMap[K, V]) Load(key K) (V, bool) {
+	return m.Get(key)
+}
+
+func (m *TieredMap[K, V]) Exist(key K) bool {
+	_, ok := m.Get(key)
+	return ok
+}
+
+func (m *TieredMap[K, V]) Count() int {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.count
+}
+
+// sync.map - compatible
+func (m *TieredMap[K, V]) Store(key K, value V) {
+	m.AddOrSet(key, value)
+}
+
+// AddOrSet stores value in the memtable, a full memtable is written to a new segment.
+// It reads segments like Get to tell whether key existed, see TieredMap.
+func (m *TieredMap[K, V]) AddOrSet(key K, value V) (overwrited bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	old, ok := m.lookup(key)
+	overwrited = ok && !old.Deleted
+	if !overwrited {
+		m.count++
+	}
+	m.mem.store(key, tieredValue[V]{Value: value})
+	m.flushIfFull()
+	return overwrited
+}
+
+// Delete stores a tombstone of key, it reads segments like Get to skip missing keys
+func (m *TieredMap[K, V]) Delete(key K) {
+	m.Lock()
+	defer m.Unlock()
+
+	old, ok := m.lookup(key)
+	if !ok || old.Deleted {
+		return
+	}
+	m.count--
+	if len(m.segments) == 0 {
+		m.mem.delete(key)
+		return
+	}
+	m.mem.store(key, tieredValue[V]{Deleted: true})
+	m.flushIfFull()
+}
+
+func (m *TieredMap[K, V]) flushIfFull() {
+	if m.mem.size < m.memEntries {
+		return
+	}
+	if err := m.flush(); err != nil {
+		m.setErr(err)
+	}
+}
+
+// Flush writes the memtable into a new segment
+func (m *TieredMap[K, V]) Flush() error {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.flush()
+}
+
+func (m *TieredMap[K, V]) flush() error {
+	if m.mem.size == 0 {
+		return nil
+	}
+
+	seq := m.seq + 1
+	s, err := writeSegment(m.dir, seq, seq, newCursor(m.mem.buffers), len(m.segments) == 0, m.count)
+	if err != nil {
+		m.log().Error("compactmap: flush failed", "dir", m.dir, "err", err)
+		return err
+	}
+	m.log().Debug("compactmap: flushed", "segment", s.path, "entries", m.mem.size)
+
+	m.segments = append(m.segments, s)
+	m.seq = seq
+	m.mem = NewCompactMap[K, tieredValue[V]]()
+
+	if len(m.segments) > m.maxSegments && !m.compacting {
+		m.compacting = true
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+
+			if err := m.Compact(); err != nil {
+				m.setErr(err)
+			}
+			m.Lock()
+			m.compacting = false
+			m.Unlock()
+		}()
+	}
+	return nil
+}
+
+// Compact merges all segments into one, dropping overwritten values and tombstones.
+// Reads and writes are not blocked while the new segment is written.
+func (m *TieredMap[K, V]) Compact() error {
+	m.compactMu.Lock()
+	defer m.compactMu.Unlock()
+
+	m.RLock()
+	segs := slices.Clone(m.segments)
+	log := m.log()
+	m.RUnlock()
+
+	if len(segs) < 2 {
+		return nil
+	}
+
+	// segments are immutable and closed only below, so they are read without the lock
+	srcs := make([]tieredSource[K, V], 0, len(segs))
+	for i := len(segs) - 1; i >= 0; i-- {
+		srcs = append(srcs, newSegmentSource(segs[i]))
+	}
+	last := segs[len(segs)-1]
+	merged, err := writeSegment(m.dir, segs[0].from, last.to, newMergeSource(srcs), true, last.index.Count)
+	if err != nil {
+		log.Error("compactmap: compaction failed", "dir", m.dir, "err", err)
+		return err
+	}
+
+	m.Lock()
+	m.segments = append([]*segment[K, V]{merged}, m.segments[len(segs):]...)
+	m.Unlock()
+
+	for _, s := range segs {
+		m.cache.drop(s)
+		s.file.Close()
+		if err := os.Remove(s.path); err != nil {
+			return err
+		}
+	}
+	log.Debug("compactmap: compacted", "segments", len(segs), "into", merged.path)
+	return nil
+}
+
+// Iterate calls fn for entries in key order until fn returns false, reading segments block by block.
+// dont modify database in iterate!
+func (m *TieredMap[K, V]) Iterate(fn func(key K, val V) bool) {
+	m.RLock()
+	defer m.RUnlock()
+
+	srcs := make([]tieredSource[K, V], 0, len(m.segments)+1)
+	srcs = append(srcs, newCursor(m.mem.buffers))
+	for i := len(m.segments) - 1; i >= 0; i-- {
+		srcs = append(srcs, newSegmentSource(m.segments[i]))
+	}
+
+	src := newMergeSource(srcs)
+	for ; src.valid(); src.next() {
+		e := src.entry()
+		if e.Value.Deleted {
+			continue
+		}
+		if !fn(e.Key, e.Value.Value) {
+			break
+		}
+	}
+	if err := src.err(); err != nil {
+		m.setErr(err)
+	}
+}
+
+// sync.Map alias
+func (m *TieredMap[K, V]) Range(fn func(key K, val V) bool) {
+	m.Iterate(fn)
+}
+
+func (m *TieredMap[K, V]) Stats() string {
+	m.RLock()
+	defer m.RUnlock()
+
+	return fmt.Sprintf("total len: %d, memtable: %d, %d segments, %d cached blocks",
+		m.count, m.mem.size, len(m.segments), m.cache.len())
+}
+
+// Close waits for background and running Compact calls, flushes the memtable and closes segment files
+func (m *TieredMap[K, V]) Close() error {
+	m.Lock()
+	err := m.flush()
+	m.Unlock()
+
+	m.wg.Wait()
+
+	// Compact swaps segments without the map lock
+	m.compactMu.Lock()
+	defer m.compactMu.Unlock()
+
+	m.Lock()
+	defer m.Unlock()
+
+	m.closeSegments()
+	return err
+}
+
+func (m *TieredMap[K, V]) closeSegments() {
+	for _, s := range m.segments {
+		s.file.Close()
+	}
+	m.segments = nil
+}
+
+// tieredSource walks sorted entries of the memtable, a segment or their merge
+type tieredSource[K constraints.Ordered, V any] interface {
+	valid() bool
+	entry() Entry[K, tieredValue[V]]
+	next()
+	err() error
+}
+
+func (c *cursor[K, V]) err() error {
+	return nil
+}
+
+// segmentSource reads blocks of a segment one by one without the cache
+type segmentSource[K constraints.Ordered, V any] struct {
+	s       *segment[K, V]
+	block   int
+	entries []Entry[K, tieredValue[V]]
+	i       int
+	e       error
+}
+
+func newSegmentSource[K constraints.Ordered, V any](s *segment[K, V]) *segmentSource[K, V] {
+	src := &segmentSource[K, V]{s: s, block: -1}
+	src.nextBlock()
+	return src
+}
+
+func (src *segmentSource[K, V]) nextBlock() {
+	src.block++
+	src.i = 0
+	src.entries = nil
+	if src.block >= len(src.s.index.Blocks) {
+		return
+	}
+	src.entries, src.e = src.s.readBlock(src.block)
+	if src.e != nil {
+		src.entries = nil
+	}
+}
+
+func (src *segmentSource[K, V]) valid() bool {
+	return src.e == nil && src.i < len(src.entries)
+}
+
+func (src *segmentSource[K, V]) entry() Entry[K, tieredValue[V]] {
+	return src.entries[src.i]
+}
+
+func (src *segmentSource[K, V]) next() {
+	src.i++
+	if src.i >= len(src.entries) {
+		src.nextBlock()
+	}
+}
+
+func (src *segmentSource[K, V]) err() error {
+	return src.e
+}
+
+// mergeSource yields the newest version of every key, srcs go from the newest to the oldest
+type mergeSource[K constraints.Ordered, V any] struct {
+	srcs []tieredSource[K, V]
+	cur  Entry[K, tieredValue[V]]
+	ok   bool
+}
+
+func newMergeSource[K constraints.Ordered, V any](srcs []tieredSource[K, V]) *mergeSource[K, V] {
+	src := &mergeSource[K, V]{srcs: srcs}
+	src.next()
+	return src
+}
+
+func (src *mergeSource[K, V]) valid() bool {
+	return src.ok
+}
+
+func (src *mergeSource[K, V]) entry() Entry[K, tieredValue[V]] {
+	return src.cur
+}
+
+func (src *mergeSource[K, V]) next() {
+	best := -1
+	for i, s := range src.srcs {
+		if s.valid() && (best < 0 || s.entry().Key < src.srcs[best].entry().Key) {
+			best = i
+		}
+	}
+	src.ok = best >= 0
+	if !src.ok {
+		return
+	}
+
+	src.cur = src.srcs[best].entry()
+	for _, s := range src.srcs {
+		if s.valid() && s.entry().Key == src.cur.Key {
+			s.next()
+		}
+	}
+}
+
+func (src *mergeSource[K, V]) err() error {
+	for _, s := range src.srcs {
+		if err := s.err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package compactmap
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func segmentFiles(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*.seg"))
+	assert.NoError(t, err)
+	return files
+}
+
+func TestTieredMap(t *testing.T) {
+	dir := t.TempDir()
+
+	m, err := OpenTieredMap[int, string](dir, 1000)
+	assert.NoError(t, err)
+	m.SetMaxSegments(100)
+
+	for i := 0; i < 5000; i++ {
+		assert.False(t, m.AddOrSet(i, "v"))
+	}
+	assert.Len(t, segmentFiles(t, dir), 5, "Full memtable should be written to segments")
+	assert.Equal(t, 5000, m.Count())
+
+	assert.True(t, m.AddOrSet(10, "new"), "Key stored in a segment should be overwritten")
+	m.Delete(20)
+	m.Delete(20)
+	m.Delete(-1)
+	assert.Equal(t, 4999, m.Count())
+
+	v, ok := m.Get(10)
+	assert.True(t, ok)
+	assert.Equal(t, "new", v)
+	assert.False(t, m.Exist(20))
+	v, ok = m.Get(4999)
+	assert.True(t, ok)
+	assert.Equal(t, "v", v)
+
+	prev, n := -1, 0
+	m.Iterate(func(key int, val string) bool {
+		assert.Greater(t, key, prev)
+		assert.NotEqual(t, 20, key)
+		if key == 10 {
+			assert.Equal(t, "new", val)
+		}
+		prev = key
+		n++
+		return true
+	})
+	assert.Equal(t, 4999, n)
+
+	assert.NoError(t, m.Close())
+	assert.NoError(t, m.Err())
+
+	// reopen
+	m, err = OpenTieredMap[int, string](dir, 1000)
+	assert.NoError(t, err)
+	assert.Equal(t, 4999, m.Count())
+	v, _ = m.Get(10)
+	assert.Equal(t, "new", v)
+	assert.False(t, m.Exist(20))
+
+	assert.NoError(t, m.Compact())
+	assert.Len(t, segmentFiles(t, dir), 1)
+	assert.Equal(t, 4999, m.Count())
+	assert.False(t, m.Exist(20))
+	assert.True(t, m.Exist(4999))
+	assert.NoError(t, m.Close())
+}
+
+func TestTieredMapBackgroundCompaction(t *testing.T) {
+	dir := t.TempDir()
+
+	m, err := OpenTieredMap[int, int](dir, 500)
+	assert.NoError(t, err)
+	m.SetMaxSegments(2)
+	m.SetCacheBlocks(2)
+
+	for i := 0; i < 10000; i++ {
+		m.AddOrSet(i%3000, i)
+	}
+	assert.NoError(t, m.Close())
+	assert.NoError(t, m.Err())
+	assert.Less(t, len(segmentFiles(t, dir)), 20, "Segments of 20 flushes should be compacted")
+
+	m, err = OpenTieredMap[int, int](dir, 500)
+	assert.NoError(t, err)
+	assert.Equal(t, 3000, m.Count())
+	for i := 0; i < 3000; i++ {
+		v, ok := m.Get(i)
+		assert.True(t, ok)
+		last := i + 6000 // the last write of key i
+		if i < 1000 {
+			last = i + 9000
+		}
+		assert.Equal(t, last, v)
+	}
+	assert.NoError(t, m.Close())
+}
+
+func TestTieredMapCloseDuringCompact(t *testing.T) {
+	dir := t.TempDir()
+
+	m, err := OpenTieredMap[int, int](dir, 5000)
+	assert.NoError(t, err)
+	m.SetMaxSegments(100)
+	for i := 0; i < 50000; i++ {
+		m.AddOrSet(i, i)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- m.Compact()
+	}()
+	time.Sleep(time.Millisecond) // let Compact start writing the merged segment
+	assert.NoError(t, m.Close())
+	assert.NoError(t, <-done)
+
+	m, err = OpenTieredMap[int, int](dir, 5000)
+	assert.NoError(t, err)
+	assert.Equal(t, 50000, m.Count())
+	v, ok := m.Get(4321)
+	assert.True(t, ok)
+	assert.Equal(t, 4321, v)
+	assert.NoError(t, m.Close())
+}
+
+// segments merged by compaction are removed on open if the process stopped before removing them
+func TestTieredMapInterruptedCompaction(t *testing.T) {
+	dir := t.TempDir()
+
+	m, err := OpenTieredMap[int, int](dir, 100)
+	assert.NoError(t, err)
+	m.SetMaxSegments(100)
+	for i := 0; i < 100; i++ {
+		m.AddOrSet(i, i)
+	}
+	for i := 0; i < 100; i++ {
+		m.Delete(i)
+	}
+	old := segmentFiles(t, dir)
+	assert.Len(t, old, 2)
+	data, err := os.ReadFile(old[0])
+	assert.NoError(t, err)
+
+	assert.NoError(t, m.Compact())
+	assert.NoError(t, m.Close())
+	assert.NoError(t, os.WriteFile(old[0], data, 0644))
+
+	m, err = OpenTieredMap[int, int](dir, 100)
+	assert.NoError(t, err)
+	assert.Len(t, segmentFiles(t, dir), 1)
+	assert.Equal(t, 0, m.Count())
+	assert.False(t, m.Exist(5), "Deleted key should not come back from a merged segment")
+	assert.NoError(t, m.Close())
+}
+
+func TestTieredMapRandom(t *testing.T) {
+	dir := t.TempDir()
+	rnd := rand.New(rand.NewSource(1))
+
+	m, err := OpenTieredMap[int, int](dir, 300)
+	assert.NoError(t, err)
+	m.SetMaxSegments(3)
+	m.SetCacheBlocks(4)
+	ref := map[int]int{}
+
+	for i := 0; i < 10000; i++ {
+		key := rnd.Intn(2000)
+		switch rnd.Intn(10) {
+		case 0, 1, 2:
+			m.Delete(key)
+			delete(ref, key)
+		case 3:
+			assert.NoError(t, m.Close())
+			m, err = OpenTieredMap[int, int](dir, 300)
+			assert.NoError(t, err)
+			m.SetMaxSegments(3)
+			m.SetCacheBlocks(4)
+		default:
+			m.AddOrSet(key, i)
+			ref[key] = i
+		}
+		if i%997 == 0 {
+			assert.Equal(t, len(ref), m.Count())
+			n := 0
+			m.Iterate(func(key, val int) bool {
+				assert.Equal(t, ref[key], val)
+				n++
+				return true
+			})
+			assert.Equal(t, len(ref), n)
+		}
+	}
+	for key, val := range ref {
+		v, ok := m.Get(key)
+		assert.True(t, ok)
+		assert.Equal(t, val, v)
+	}
+	assert.NoError(t, m.Close())
+	assert.NoError(t, m.Err())
+}
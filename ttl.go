@@ -0,0 +1,146 @@
+package compactmap
+
+import (
+	"time"
+)
+
+// AddOrSetWithTTL adds or sets the value which expires after ttl.
+// Expired entries are not returned by Get, Exist and Iterate,
+// they are removed on access or by the janitor, see EnableJanitor. Count includes them until then.
+// ttl <= 0 means no expiration, same as AddOrSet.
+func (m *CompactMap[K, V]) AddOrSetWithTTL(key K, value V, ttl time.Duration) (overwrited bool) {
+	m.Lock()
+	defer m.unlock()
+
+	overwrited = m.addOrSet(key, value)
+	if ttl > 0 {
+		m.setExpiration(key, time.Now().Add(ttl).UnixNano())
+	}
+	return overwrited
+}
+
+// TTL returns time left before key expires.
+// ok is false if key is not found or has no expiration.
+func (m *CompactMap[K, V]) TTL(key K) (ttl time.Duration, ok bool) {
+	m.RLock()
+	defer m.RUnlock()
+
+	if m.expires == nil {
+		return 0, false
+	}
+	if _, found := m.get(key); !found {
+		return 0, false
+	}
+	exp, ok := m.expires.get(key)
+	if !ok {
+		return 0, false
+	}
+	return time.Duration(exp - time.Now().UnixNano()), true
+}
+
+func (m *CompactMap[K, V]) setExpiration(key K, unixNano int64) {
+	if _, _, found := m.find(key); !found {
+		// evicted
+		return
+	}
+	if m.expires == nil {
+		m.expires = NewCompactMap[K, int64]()
+	}
+	m.expires.addOrSet(key, unixNano)
+}
+
+func (m *CompactMap[K, V]) isExpired(key K, now int64) bool {
+	if m.expires == nil {
+		return false
+	}
+	bufferIndex, index, found := m.expires.find(key)
+	return found && (*m.expires.buffers[bufferIndex])[index].Value <= now
+}
+
+// expiration returns expiration time of key or 0
+func (m *CompactMap[K, V]) expiration(key K) int64 {
+	bufferIndex, index, found := m.expires.find(key)
+	if !found {
+		return 0
+	}
+	return (*m.expires.buffers[bufferIndex])[index].Value
+}
+
+// deleteExpired removes key if it is still expired
+func (m *CompactMap[K, V]) deleteExpired(key K) {
+	m.Lock()
+	defer m.unlock()
+
+	if m.isExpired(key, time.Now().UnixNano()) {
+		m.delete(key)
+	}
+}
+
+// DeleteExpired removes all expired entries and returns their count
+func (m *CompactMap[K, V]) DeleteExpired() int {
+	m.Lock()
+	defer m.unlock()
+
+	if m.expires == nil {
+		return 0
+	}
+
+	now := time.Now().UnixNano()
+	var keys []K
+	for _, buffer := range m.expires.buffers {
+		for _, e := range *buffer {
+			if e.Value <= now {
+				keys = append(keys, e.Key)
+			}
+		}
+	}
+	for _, key := range keys {
+		m.delete(key)
+	}
+	return len(keys)
+}
+
+// EnableJanitor starts background removal of expired entries every interval.
+// Calling it again restarts the janitor with the new interval. It panics if interval is not positive.
+func (m *CompactMap[K, V]) EnableJanitor(interval time.Duration) {
+	if interval <= 0 {
+		panic("compactmap: non-positive janitor interval")
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	m.stopJanitor()
+
+	stop := make(chan struct{})
+	m.janitorStop = stop
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				m.DeleteExpired()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// DisableJanitor stops background removal of expired entries
+func (m *CompactMap[K, V]) DisableJanitor() {
+	m.Lock()
+	defer m.Unlock()
+
+	m.stopJanitor()
+}
+
+func (m *CompactMap[K, V]) stopJanitor() {
+	if m.janitorStop != nil {
+		close(m.janitorStop)
+		m.janitorStop = nil
+	}
+}
@@ -0,0 +1,137 @@
+package compactmap
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/constraints"
+)
+
+func TestTTLExpiration(t *testing.T) {
+	cm := NewCompactMap[int, int]()
+	cm.AddOrSet(1, 100)
+	cm.AddOrSetWithTTL(2, 200, 50*time.Millisecond)
+	cm.AddOrSetWithTTL(3, 300, time.Hour)
+
+	value, exists := cm.Get(2)
+	assert.True(t, exists, "Value for key 2 should exist before expiration")
+	assert.Equal(t, 200, value)
+	assert.Equal(t, 3, cm.Count())
+
+	ttl, ok := cm.TTL(3)
+	assert.True(t, ok)
+	assert.True(t, ttl > 59*time.Minute)
+	_, ok = cm.TTL(1)
+	assert.False(t, ok, "Key without ttl should not report it")
+
+	time.Sleep(100 * time.Millisecond)
+
+	assert.Equal(t, 3, cm.Count(), "Expired entries are counted until removed")
+	assert.False(t, cm.Exist(2), "Expired entry should not exist")
+	_, exists = cm.Get(2)
+	assert.False(t, exists, "Expired entry should not be returned")
+	assert.Equal(t, 2, cm.Count(), "Get should remove the expired entry")
+
+	var keys []int
+	cm.Iterate(func(key, value int) bool {
+		keys = append(keys, key)
+		return true
+	})
+	assert.Equal(t, []int{1, 3}, keys, "Iterate should skip expired entries")
+
+	// removed lazily on access
+	cm.RLock()
+	assert.Equal(t, 2, cm.count())
+	cm.RUnlock()
+}
+
+func TestTTLOverwrite(t *testing.T) {
+	cm := NewCompactMap[int, int]()
+	cm.AddOrSetWithTTL(1, 100, 50*time.Millisecond)
+	cm.AddOrSet(1, 101)
+
+	time.Sleep(100 * time.Millisecond)
+	value, exists := cm.Get(1)
+	assert.True(t, exists, "AddOrSet should remove expiration")
+	assert.Equal(t, 101, value)
+
+	cm.AddOrSetWithTTL(2, 200, 50*time.Millisecond)
+	value, loaded := cm.LoadOrStore(2, 201)
+	assert.True(t, loaded)
+	assert.Equal(t, 200, value)
+
+	time.Sleep(100 * time.Millisecond)
+	value, loaded = cm.LoadOrStore(2, 202)
+	assert.False(t, loaded, "Expired entry should be replaced by LoadOrStore")
+	assert.Equal(t, 202, value)
+	_, ok := cm.TTL(2)
+	assert.False(t, ok)
+}
+
+func TestTTLJanitor(t *testing.T) {
+	cm := NewCompactMap[int, int]()
+	for i := 0; i < 3000; i++ {
+		cm.AddOrSetWithTTL(i, i, 20*time.Millisecond)
+	}
+	cm.AddOrSet(5000, 5000)
+
+	cm.EnableJanitor(10 * time.Millisecond)
+	defer cm.DisableJanitor()
+
+	assert.Eventually(t, func() bool {
+		cm.RLock()
+		defer cm.RUnlock()
+		return cm.count() == 1
+	}, time.Second, 10*time.Millisecond, "Janitor should remove expired entries")
+
+	assert.True(t, cm.Exist(5000))
+	assert.Equal(t, 0, cm.DeleteExpired())
+}
+
+func TestTTLJanitorBadInterval(t *testing.T) {
+	cm := NewCompactMap[int, int]()
+	assert.Panics(t, func() { cm.EnableJanitor(0) })
+	assert.Panics(t, func() { cm.EnableJanitor(-time.Second) })
+}
+
+func TestTTLSaveAndLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ttl.dat")
+
+	cm := NewCompactMap[int, int]()
+	cm.AddOrSet(1, 100)
+	cm.AddOrSetWithTTL(2, 200, time.Hour)
+	cm.AddOrSetWithTTL(3, 300, 30*time.Millisecond)
+	cm.AddOrSetWithTTL(4, 400, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	assert.Nil(t, cm.Save(file))
+
+	cm2 := NewCompactMap[int, int]()
+	assert.Nil(t, cm2.Init(file))
+	assert.Equal(t, 3, cm2.Count(), "Entries expired before save should not be stored")
+
+	ttl, ok := cm2.TTL(2)
+	assert.True(t, ok, "Expiration should be preserved")
+	assert.True(t, ttl > 59*time.Minute && ttl <= time.Hour)
+	_, ok = cm2.TTL(1)
+	assert.False(t, ok)
+
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, cm2.Exist(3))
+	assert.Equal(t, 2, cm2.Count())
+}
+
+// saveExpiring saves keys 0..9 into file, odd keys expire right after the save
+func saveExpiring[K constraints.Ordered, V any](t *testing.T, file string, key func(i int) K, value V) {
+	cm := NewCompactMap[K, V]()
+	for i := 0; i < 10; i++ {
+		if i%2 == 0 {
+			cm.AddOrSet(key(i), value)
+		} else {
+			cm.AddOrSetWithTTL(key(i), value, 50*time.Millisecond)
+		}
+	}
+	assert.Nil(t, cm.Save(file))
+	time.Sleep(60 * time.Millisecond)
+}
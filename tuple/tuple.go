@@ -0,0 +1,195 @@
+/*
+Package tuple encodes composite keys like (tenantID, timestamp)
+into strings ordered the same way as tuples: by the first element,
+then by the second and so on. Such keys can be used with CompactMap,
+and IterateRange with PrefixRange scans all entries with given leading elements.
+
+Supported elements: bool, signed and unsigned integers, float32/64,
+time.Time, string and []byte. Values of different types at the same position
+are ordered by type, so keep types of each position fixed.
+*/
+package tuple
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+var ErrBadTuple = errors.New("tuple: bad encoding")
+
+const (
+	tagBool   byte = 0x01
+	tagInt    byte = 0x02
+	tagUint   byte = 0x03
+	tagFloat  byte = 0x04
+	tagTime   byte = 0x05
+	tagString byte = 0x06
+	tagBytes  byte = 0x07
+)
+
+// Pack encodes values into an order preserving key.
+// Panics on unsupported types.
+func Pack(values ...any) string {
+	var b []byte
+	for _, v := range values {
+		b = appendValue(b, v)
+	}
+	return string(b)
+}
+
+// PrefixRange returns bounds for IterateRange which match all keys
+// starting with the packed values: from <= key < to
+func PrefixRange(values ...any) (from, to string) {
+	from = Pack(values...)
+	return from, from + "\xff"
+}
+
+func appendValue(b []byte, v any) []byte {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return append(b, tagBool, 1)
+		}
+		return append(b, tagBool, 0)
+	case int:
+		return appendInt(b, int64(v))
+	case int8:
+		return appendInt(b, int64(v))
+	case int16:
+		return appendInt(b, int64(v))
+	case int32:
+		return appendInt(b, int64(v))
+	case int64:
+		return appendInt(b, v)
+	case uint:
+		return appendUint(b, uint64(v))
+	case uint8:
+		return appendUint(b, uint64(v))
+	case uint16:
+		return appendUint(b, uint64(v))
+	case uint32:
+		return appendUint(b, uint64(v))
+	case uint64:
+		return appendUint(b, v)
+	case float32:
+		return appendFloat(b, float64(v))
+	case float64:
+		return appendFloat(b, v)
+	case time.Time:
+		b = append(b, tagTime)
+		return binary.BigEndian.AppendUint64(b, uint64(v.UnixNano())^(1<<63))
+	case string:
+		return appendEscaped(append(b, tagString), v)
+	case []byte:
+		return appendEscaped(append(b, tagBytes), string(v))
+	}
+	panic(fmt.Sprintf("tuple: unsupported type %T", v))
+}
+
+// integers are big endian with flipped sign bit, so negative go first
+func appendInt(b []byte, v int64) []byte {
+	b = append(b, tagInt)
+	return binary.BigEndian.AppendUint64(b, uint64(v)^(1<<63))
+}
+
+func appendUint(b []byte, v uint64) []byte {
+	b = append(b, tagUint)
+	return binary.BigEndian.AppendUint64(b, v)
+}
+
+// positive floats get sign bit set, negative are inverted
+func appendFloat(b []byte, v float64) []byte {
+	bits := math.Float64bits(v)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	b = append(b, tagFloat)
+	return binary.BigEndian.AppendUint64(b, bits)
+}
+
+// strings are terminated by 0x00, zero bytes inside are escaped as 0x00 0xff
+func appendEscaped(b []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		b = append(b, s[i])
+		if s[i] == 0 {
+			b = append(b, 0xff)
+		}
+	}
+	return append(b, 0)
+}
+
+// Unpack decodes key made by Pack. Integers are returned as int64 or uint64,
+// floats as float64.
+func Unpack(key string) ([]any, error) {
+	var values []any
+	for len(key) > 0 {
+		tag := key[0]
+		key = key[1:]
+		switch tag {
+		case tagBool:
+			if len(key) < 1 {
+				return nil, ErrBadTuple
+			}
+			values = append(values, key[0] != 0)
+			key = key[1:]
+		case tagInt, tagUint, tagFloat, tagTime:
+			if len(key) < 8 {
+				return nil, ErrBadTuple
+			}
+			u := binary.BigEndian.Uint64([]byte(key[:8]))
+			key = key[8:]
+			switch tag {
+			case tagInt:
+				values = append(values, int64(u^(1<<63)))
+			case tagUint:
+				values = append(values, u)
+			case tagFloat:
+				if u&(1<<63) != 0 {
+					u &^= 1 << 63
+				} else {
+					u = ^u
+				}
+				values = append(values, math.Float64frombits(u))
+			case tagTime:
+				values = append(values, time.Unix(0, int64(u^(1<<63))))
+			}
+		case tagString, tagBytes:
+			s, rest, err := unescape(key)
+			if err != nil {
+				return nil, err
+			}
+			key = rest
+			if tag == tagString {
+				values = append(values, s)
+			} else {
+				values = append(values, []byte(s))
+			}
+		default:
+			return nil, ErrBadTuple
+		}
+	}
+	return values, nil
+}
+
+func unescape(key string) (s, rest string, err error) {
+	var sb strings.Builder
+	for i := 0; i < len(key); i++ {
+		if key[i] != 0 {
+			sb.WriteByte(key[i])
+			continue
+		}
+		if i+1 < len(key) && key[i+1] == 0xff {
+			sb.WriteByte(0)
+			i++
+			continue
+		}
+		return sb.String(), key[i+1:], nil
+	}
+	return "", "", ErrBadTuple
+}
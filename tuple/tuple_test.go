@@ -0,0 +1,63 @@
+package tuple
+
+import (
+	"math"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPackUnpack(t *testing.T) {
+	now := time.Unix(0, time.Now().UnixNano())
+	values := []any{true, int64(-7), uint64(42), 1.5, now, "a\x00b", []byte{0, 1, 0xff}}
+
+	got, err := Unpack(Pack(values...))
+	assert.Nil(t, err)
+	assert.Equal(t, len(values), len(got))
+	for i := range values {
+		if tm, ok := values[i].(time.Time); ok {
+			assert.True(t, tm.Equal(got[i].(time.Time)))
+			continue
+		}
+		assert.Equal(t, values[i], got[i])
+	}
+
+	_, err = Unpack("\x06abc")
+	assert.ErrorIs(t, err, ErrBadTuple)
+	_, err = Unpack("\x02abc")
+	assert.ErrorIs(t, err, ErrBadTuple)
+}
+
+func TestOrder(t *testing.T) {
+	sorted := [][]any{
+		{-5, "z"},
+		{-1, ""},
+		{0, "a"},
+		{0, "a", 1},
+		{0, "a\x00"},
+		{0, "ab"},
+		{7, "b", math.Inf(-1)},
+		{7, "b", -2.5},
+		{7, "b", 0.0},
+		{7, "b", 3.25},
+		{7, "b", math.Inf(1)},
+		{1 << 40, ""},
+	}
+
+	keys := make([]string, len(sorted))
+	for i, v := range sorted {
+		keys[i] = Pack(v...)
+	}
+	assert.True(t, sort.StringsAreSorted(keys))
+}
+
+func TestPrefixRange(t *testing.T) {
+	from, to := PrefixRange(7)
+	assert.True(t, Pack(7) >= from)
+	assert.True(t, Pack(7, "x", 1) < to)
+	assert.True(t, Pack(7, "\xff\xff") < to)
+	assert.True(t, Pack(6, "z") < from)
+	assert.True(t, Pack(8) >= to)
+}
@@ -0,0 +1,101 @@
+package compactmap
+
+import (
+	"cmp"
+	"errors"
+
+	"golang.org/x/exp/constraints"
+)
+
+var ErrTxDone = errors.New("compactmap: transaction already committed or rolled back")
+
+type txWrite[V any] struct {
+	value   V
+	deleted bool
+}
+
+/*
+	Tx groups AddOrSet and Delete operations applied atomically by Commit.
+	Writes are kept in the transaction until Commit, Get sees them over the committed state of the map.
+	Transactions do not detect conflicts: the last commit wins.
+	Tx is not safe for concurrent use.
+*/
+
+type Tx[K constraints.Ordered, V any] struct {
+	m      *CompactMap[K, V]
+	writes *CompactMapFunc[K, txWrite[V]] // sorted by key, so Commit applies writes in order
+	done   bool
+}
+
+// Begin starts a transaction
+func (m *CompactMap[K, V]) Begin() *Tx[K, V] {
+	return &Tx[K, V]{m: m, writes: NewCompactMapFunc[K, txWrite[V]](cmp.Compare[K])}
+}
+
+// Update runs fn in a transaction, commits it if fn returns nil and rolls it back otherwise
+func (m *CompactMap[K, V]) Update(fn func(tx *Tx[K, V]) error) error {
+	tx := m.Begin()
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Get returns value written in the transaction or stored in the map
+func (tx *Tx[K, V]) Get(key K) (V, bool) {
+	if w, ok := tx.writes.Get(key); ok {
+		if w.deleted {
+			var zero V
+			return zero, false
+		}
+		return w.value, true
+	}
+	return tx.m.Get(key)
+}
+
+// Set returns ErrTxDone after Commit or Rollback
+func (tx *Tx[K, V]) Set(key K, value V) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.writes.AddOrSet(key, txWrite[V]{value: value})
+	return nil
+}
+
+// Delete returns ErrTxDone after Commit or Rollback
+func (tx *Tx[K, V]) Delete(key K) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.writes.AddOrSet(key, txWrite[V]{deleted: true})
+	return nil
+}
+
+// Commit applies writes under one write lock, readers see all of them or none
+func (tx *Tx[K, V]) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+
+	m := tx.m
+	m.Lock()
+	defer m.unlock()
+
+	for c := newCursor(tx.writes.buffers); c.valid(); c.next() {
+		e := c.entry()
+		if e.Value.deleted {
+			m.delete(e.Key)
+		} else {
+			m.addOrSet(e.Key, e.Value.value)
+		}
+	}
+	return nil
+}
+
+// Rollback discards writes of the transaction
+func (tx *Tx[K, V]) Rollback() {
+	tx.done = true
+	tx.writes.Clear()
+}
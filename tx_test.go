@@ -0,0 +1,108 @@
+package compactmap
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTx(t *testing.T) {
+	cm := mapOf(1, 10, 2, 20)
+
+	tx := cm.Begin()
+	tx.Set(3, 30)
+	tx.Delete(1)
+	tx.Set(2, 21)
+
+	v, ok := tx.Get(3)
+	assert.True(t, ok)
+	assert.Equal(t, 30, v)
+	_, ok = tx.Get(1)
+	assert.False(t, ok)
+
+	// not visible before commit
+	assert.True(t, cm.Exist(1))
+	assert.False(t, cm.Exist(3))
+
+	assert.Nil(t, tx.Commit())
+	assert.ErrorIs(t, tx.Commit(), ErrTxDone)
+	assert.True(t, cm.Equal(mapOf(2, 21, 3, 30), nil))
+
+	tx = cm.Begin()
+	tx.Delete(2)
+	tx.Rollback()
+	assert.ErrorIs(t, tx.Commit(), ErrTxDone)
+	assert.True(t, cm.Exist(2))
+
+	// writes after the end are rejected
+	assert.ErrorIs(t, tx.Set(5, 50), ErrTxDone)
+	assert.ErrorIs(t, tx.Delete(2), ErrTxDone)
+	tx = cm.Begin()
+	assert.Nil(t, tx.Set(4, 40))
+	assert.Nil(t, tx.Commit())
+	assert.ErrorIs(t, tx.Set(5, 50), ErrTxDone)
+	assert.ErrorIs(t, tx.Delete(4), ErrTxDone)
+	assert.False(t, cm.Exist(5))
+	assert.True(t, cm.Exist(4))
+}
+
+func TestUpdate(t *testing.T) {
+	cm := mapOf(1, 10)
+
+	fail := errors.New("fail")
+	err := cm.Update(func(tx *Tx[int, int]) error {
+		tx.Set(2, 20)
+		return fail
+	})
+	assert.ErrorIs(t, err, fail)
+	assert.False(t, cm.Exist(2))
+
+	assert.Nil(t, cm.Update(func(tx *Tx[int, int]) error {
+		v, _ := tx.Get(1)
+		tx.Set(1, v+1)
+		return nil
+	}))
+	v, _ := cm.Get(1)
+	assert.Equal(t, 11, v)
+}
+
+func TestTxAtomic(t *testing.T) {
+	cm := NewCompactMap[int, int]()
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+
+	// writer moves value between keys keeping the sum
+	cm.AddOrSet(0, 100)
+	cm.AddOrSet(1, 0)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			cm.Update(func(tx *Tx[int, int]) error {
+				a, _ := tx.Get(0)
+				b, _ := tx.Get(1)
+				tx.Set(0, a-1)
+				tx.Set(1, b+1)
+				return nil
+			})
+		}
+		close(stop)
+	}()
+
+	for done := false; !done; {
+		select {
+		case <-stop:
+			done = true
+		default:
+		}
+		sum := 0
+		cm.Iterate(func(key, val int) bool {
+			sum += val
+			return true
+		})
+		assert.Equal(t, 100, sum)
+	}
+	wg.Wait()
+}
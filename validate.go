@@ -0,0 +1,62 @@
+package compactmap
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrInvalid = errors.New("compactmap: invalid map")
+
+// Validate checks internal invariants: buffers are not empty, not longer than maxSliceSize,
+// sorted without duplicates and ordered between each other, Count matches the entries
+// and expiration times belong to stored keys. Returned errors wrap ErrInvalid.
+func (m *CompactMap[K, V]) Validate() error {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.validate()
+}
+
+func (m *CompactMap[K, V]) validate() error {
+	total := 0
+	for i, buffer := range m.buffers {
+		if buffer == nil {
+			return fmt.Errorf("%w: buffer %d is nil", ErrInvalid, i)
+		}
+		b := *buffer
+		if len(b) == 0 {
+			return fmt.Errorf("%w: buffer %d is empty", ErrInvalid, i)
+		}
+		if len(b) > maxSliceSize {
+			return fmt.Errorf("%w: buffer %d has %d entries, limit is %d", ErrInvalid, i, len(b), maxSliceSize)
+		}
+		for j := 1; j < len(b); j++ {
+			if !(b[j-1].Key < b[j].Key) {
+				return fmt.Errorf("%w: buffer %d is not sorted at %d: %v, %v", ErrInvalid, i, j, b[j-1].Key, b[j].Key)
+			}
+		}
+		if i > 0 {
+			prev := *m.buffers[i-1]
+			if !(prev[len(prev)-1].Key < b[0].Key) {
+				return fmt.Errorf("%w: buffers %d and %d overlap: %v, %v", ErrInvalid, i-1, i, prev[len(prev)-1].Key, b[0].Key)
+			}
+		}
+		total += len(b)
+	}
+
+	if total != m.size {
+		return fmt.Errorf("%w: count %d, but buffers hold %d entries", ErrInvalid, m.size, total)
+	}
+
+	if m.expires != nil {
+		if err := m.expires.validate(); err != nil {
+			return fmt.Errorf("expiration times: %w", err)
+		}
+		for c := newCursor(m.expires.buffers); c.valid(); c.next() {
+			if _, _, found := m.find(c.entry().Key); !found {
+				return fmt.Errorf("%w: expiration time of missing key %v", ErrInvalid, c.entry().Key)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package compactmap
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	cm := NewCompactMap[int, int]()
+	assert.Nil(t, cm.Validate())
+	for i := 0; i < 2500; i++ {
+		cm.AddOrSet(i, i)
+	}
+	cm.AddOrSetWithTTL(5000, 0, time.Hour)
+	assert.Nil(t, cm.Validate())
+
+	(*cm.buffers[0])[1].Key = 100
+	assert.ErrorIs(t, cm.Validate(), ErrInvalid)
+	(*cm.buffers[0])[1].Key = 1
+
+	(*cm.buffers[1])[0].Key = 0
+	assert.ErrorIs(t, cm.Validate(), ErrInvalid)
+	(*cm.buffers[1])[0].Key = 1000
+
+	cm.size++
+	assert.ErrorIs(t, cm.Validate(), ErrInvalid)
+	cm.size--
+
+	empty := []Entry[int, int]{}
+	cm.buffers = append(cm.buffers, &empty)
+	assert.ErrorIs(t, cm.Validate(), ErrInvalid)
+	cm.buffers = cm.buffers[:len(cm.buffers)-1]
+
+	cm.expires.store(-1, 0)
+	assert.ErrorIs(t, cm.Validate(), ErrInvalid)
+	cm.expires.delete(-1)
+
+	assert.Nil(t, cm.Validate())
+
+	file := filepath.Join(t.TempDir(), "valid.dat")
+	assert.Nil(t, cm.Save(file))
+	loaded := NewCompactMap[int, int]()
+	assert.Nil(t, loaded.Init(file))
+	assert.Nil(t, loaded.Validate())
+}
+
+// FuzzOperations applies random operations to CompactMap and a reference map.
+// Every 3 bytes of input are an operation and a key.
+func FuzzOperations(f *testing.F) {
+	f.Add([]byte{0, 0, 1, 1, 0, 1, 4, 0, 0, 2, 0, 1})
+	f.Add([]byte{4, 1, 0, 4, 0, 200, 1, 0, 250, 3, 1, 0, 5, 0, 0})
+
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		cm := NewCompactMap[int16, int]()
+		ref := make(map[int16]int)
+
+		for i := 0; i+3 <= len(ops); i += 3 {
+			key := int16(binary.LittleEndian.Uint16(ops[i+1:]))
+			switch ops[i] % 6 {
+			case 0:
+				_, exists := ref[key]
+				if cm.AddOrSet(key, i) != exists {
+					t.Fatalf("AddOrSet(%d) overwrite mismatch", key)
+				}
+				ref[key] = i
+			case 1:
+				cm.Delete(key)
+				delete(ref, key)
+			case 2:
+				v, ok := cm.Get(key)
+				rv, rok := ref[key]
+				if ok != rok || v != rv {
+					t.Fatalf("Get(%d) = %d, %v, want %d, %v", key, v, ok, rv, rok)
+				}
+			case 3:
+				old, loaded := cm.LoadAndDelete(key)
+				rv, rok := ref[key]
+				if loaded != rok || old != rv {
+					t.Fatalf("LoadAndDelete(%d) mismatch", key)
+				}
+				delete(ref, key)
+			case 4:
+				// bulk insert makes buffers split
+				for j := 0; j < 700; j++ {
+					k := key + int16(j*7)
+					cm.AddOrSet(k, j)
+					ref[k] = j
+				}
+			case 5:
+				cm.Clear()
+				clear(ref)
+			}
+
+			if err := cm.Validate(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if cm.Count() != len(ref) {
+			t.Fatalf("Count() = %d, want %d", cm.Count(), len(ref))
+		}
+		for k, v := range ref {
+			if got, ok := cm.Get(k); !ok || got != v {
+				t.Fatalf("Get(%d) = %d, %v, want %d", k, got, ok, v)
+			}
+		}
+	})
+}